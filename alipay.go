@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/gogf/gf/v2/os/glog"
@@ -16,8 +19,16 @@ const (
 	_ALIPAY_LOG_TAG   = "[Alipay]"
 	_ALIPAY_LOG_PATH  = "./log/alipay"
 	_ALIPAY_LOG_LEVEL = "error"
+
+	_ALIPAY_SCENE_BAR_CODE          = "bar_code"
+	_ALIPAY_TRADE_PAY_TIMEOUT       = 60 * time.Second // 条码支付默认等待用户付款时间
+	_ALIPAY_TRADE_PAY_POLL_INTERVAL = 3 * time.Second  // 条码支付轮询查询间隔
+	_ALIPAY_TRADE_CANCEL_TIMEOUT    = 10 * time.Second // 撤销交易请求超时时间
+	_ALIPAY_TIME_LAYOUT             = "2006-01-02 15:04:05"
 )
 
+var _ALIPAY_LOCATION = time.FixedZone("CST", 8*3600) // 支付宝接口时间均为北京时间
+
 type AlipayConfig struct {
 	PaymentKey       any
 	AppId            string
@@ -25,12 +36,49 @@ type AlipayConfig struct {
 	AlipayPublicCert string // 支付宝公钥
 	IsProd           bool   // 是否生产环境
 	SuccessURL       string // 成功回调URL
+	TradePayTimeout  int    // 条码支付等待用户付款的超时时间(秒)，为 0 时使用默认值 60 秒
+
+	HTTPClient *http.Client // 请求支付宝接口的 HTTP 客户端，为空时使用默认客户端
+}
+
+type AlipayExtraForTradePreCreateReq struct {
+	TimeoutExpress string               `json:"timeoutExpress"` // 订单相对超时时间，取值范围：1m～15d，如 90m
+	TimeExpire     time.Time            `json:"timeExpire"`     // 订单绝对超时时间，与 TimeoutExpress 同时设置时以支付宝规则为准
+	Body           string               `json:"body"`           // 订单描述
+	GoodsDetail    []*AlipayGoodsDetail `json:"goodsDetail"`    // 订单包含的商品列表
+	PassbackParams string               `json:"passbackParams"` // 公用回传参数，异步通知时原样返回
+	SellerId       string               `json:"sellerId"`       // 收款支付宝用户ID，为空时默认为商户签约账号
+	StoreId        string               `json:"storeId"`        // 商户门店编号
+}
+
+type AlipayGoodsDetail struct {
+	GoodsId       string `json:"goodsId"`       // 商品编号
+	GoodsName     string `json:"goodsName"`     // 商品名称
+	Quantity      int    `json:"quantity"`      // 商品数量
+	Price         int64  `json:"price"`         // 商品单价，与订单总价货币相同的最小单位
+	GoodsCategory string `json:"goodsCategory"` // 商品类目
+	Body          string `json:"body"`          // 商品描述
+	ShowURL       string `json:"showURL"`       // 商品展示地址
+}
+
+type AlipayExtraForNotifyEvent struct {
+	PassbackParams string `json:"passbackParams"` // 公用回传参数
+	TotalAmount    string `json:"totalAmount"`    // 订单金额，单位为元
+	BuyerPayAmount string `json:"buyerPayAmount"` // 买家付款金额，单位为元
+}
+
+type AlipayExtraForTradePayRes struct {
+	TradeNo        string `json:"tradeNo"`        // 支付宝交易号
+	BuyerLogonId   string `json:"buyerLogonId"`   // 买家支付宝账号
+	BuyerPayAmount string `json:"buyerPayAmount"` // 买家实付金额，单位为元
+	GmtPayment     string `json:"gmtPayment"`     // 交易付款时间
 }
 type AlipayClient struct {
 	config          AlipayConfig
 	client          *alipay.Client
 	logger          *glog.Logger
 	fulfillCheckout func(string)
+	eventHandler    func(*NotifyEvent)
 }
 
 func NewAlipayClient(config AlipayConfig, fulfillCheckout func(string)) (*AlipayClient, error) {
@@ -41,7 +89,7 @@ func NewAlipayClient(config AlipayConfig, fulfillCheckout func(string)) (*Alipay
 	l.SetPrefix(_ALIPAY_LOG_TAG)
 	l.SetStack(false)
 
-	client, err := alipay.New(config.AppId, config.AppPrivateKey, config.IsProd)
+	client, err := alipay.New(config.AppId, config.AppPrivateKey, config.IsProd, alipay.WithHTTPClient(config.HTTPClient))
 	if err != nil {
 		return nil, err
 	}
@@ -74,6 +122,10 @@ func (a *AlipayClient) TradePrecreate(ctx context.Context, req *TradePreCreateRe
 	order.Subject = req.ProductSubject
 	order.OutTradeNo = req.OutTradeNo
 	order.TotalAmount = fmt.Sprintf("%.2f", amount) // alipay 要求：订单总金额。单位为元，精确到小数点后两位，取值范围：[0.01,100000000] 。【示例值】88.88
+	order.GoodsDetail, err = a.applyExtra(ctx, &order.Trade, req)
+	if err != nil {
+		return nil, err
+	}
 
 	//	alipay.trade.precreate(统一收单线下交易预创建)
 	//	https://opendocs.alipay.com/open/8ad49e4a_alipay.trade.precreate
@@ -87,6 +139,161 @@ func (a *AlipayClient) TradePrecreate(ctx context.Context, req *TradePreCreateRe
 	}, nil
 }
 
+// applyExtra 处理支付宝扩展参数，返回转换后的商品明细
+func (a *AlipayClient) applyExtra(ctx context.Context, trade *alipay.Trade, req *TradePreCreateReq) (goods []*alipay.GoodsDetailItem, err error) {
+	ex, ok := req.Extra.(AlipayExtraForTradePreCreateReq)
+	if !ok {
+		return nil, nil
+	}
+	trade.TimeoutExpress = ex.TimeoutExpress
+	if !ex.TimeExpire.IsZero() {
+		trade.TimeExpire = ex.TimeExpire.In(_ALIPAY_LOCATION).Format(_ALIPAY_TIME_LAYOUT)
+	}
+	trade.Body = ex.Body
+	trade.SellerId = ex.SellerId
+	trade.StoreId = ex.StoreId
+	if ex.PassbackParams != "" {
+		trade.PassbackParams = url.QueryEscape(ex.PassbackParams) // alipay 要求：必须进行UrlEncode之后才可以发送给支付宝
+	}
+
+	for _, v := range ex.GoodsDetail {
+		price, err := ERInstance.ConvertToStandard(ctx, v.Price, req.Currency, CurrencyCNY)
+		if err != nil {
+			return nil, err
+		}
+		goods = append(goods, &alipay.GoodsDetailItem{
+			GoodsId:       v.GoodsId,
+			GoodsName:     v.GoodsName,
+			Quantity:      strconv.Itoa(v.Quantity),
+			Price:         fmt.Sprintf("%.2f", price),
+			GoodsCategory: v.GoodsCategory,
+			Body:          v.Body,
+			ShowUrl:       v.ShowURL,
+		})
+	}
+	return
+}
+
+// TradePay 当面付条码支付(商家扫用户付款码)，同步等待支付结果
+//
+//	alipay.trade.pay(统一收单交易支付接口)
+//	https://docs.open.alipay.com/api_1/alipay.trade.pay/
+//
+// 返回 10003(等待用户输入密码) 时，轮询 alipay.trade.query 直到支付成功或超时，超时后撤销交易
+func (a *AlipayClient) TradePay(ctx context.Context, authCode string, req *TradePreCreateReq) (res *TradePreCreateRes, err error) {
+	// 处理汇率
+	amount, err := ERInstance.ConvertToStandard(ctx, req.TotalAmount, req.Currency, CurrencyCNY)
+	if err != nil {
+		return nil, err
+	}
+	if amount < 0.01 {
+		amount = 0.01
+	}
+
+	var order alipay.TradePay
+	order.NotifyURL = a.config.SuccessURL
+	order.Subject = req.ProductSubject
+	order.OutTradeNo = req.OutTradeNo
+	order.TotalAmount = fmt.Sprintf("%.2f", amount)
+	order.Scene = _ALIPAY_SCENE_BAR_CODE
+	order.AuthCode = authCode
+	order.GoodsDetail, err = a.applyExtra(ctx, &order.Trade, req)
+	if err != nil {
+		return nil, err
+	}
+
+	aliRes, err := a.client.TradePay(ctx, order)
+	if err != nil {
+		// 网络异常时支付结果未知，撤销交易，避免用户稍后付款成功
+		a.tradeCancel(ctx, req.OutTradeNo)
+		return nil, err
+	}
+	a.logger.Debugf(ctx, "alipay TradePay OutTradeNo: %s, code: %s", req.OutTradeNo, aliRes.Code)
+
+	switch aliRes.Code {
+	case alipay.CodeSuccess:
+		return &TradePreCreateRes{
+			OutTradeNo: req.OutTradeNo,
+			Extra: AlipayExtraForTradePayRes{
+				TradeNo:        aliRes.TradeNo,
+				BuyerLogonId:   aliRes.BuyerLogonId,
+				BuyerPayAmount: aliRes.BuyerPayAmount,
+				GmtPayment:     aliRes.GmtPayment,
+			},
+		}, nil
+	case alipay.CodeOrderSuccessPayInProcess:
+		// 等待用户输入密码，轮询查询结果
+		return a.waitTradePay(ctx, req.OutTradeNo)
+	default:
+		// 支付失败或结果未知，撤销交易，避免用户稍后付款成功
+		a.tradeCancel(ctx, req.OutTradeNo)
+		return nil, aliRes.Error
+	}
+}
+
+// waitTradePay 轮询查询条码支付结果，超时则撤销交易
+func (a *AlipayClient) waitTradePay(ctx context.Context, outTradeNo string) (res *TradePreCreateRes, err error) {
+	timeout := _ALIPAY_TRADE_PAY_TIMEOUT
+	if a.config.TradePayTimeout > 0 {
+		timeout = time.Duration(a.config.TradePayTimeout) * time.Second
+	}
+	pollCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(_ALIPAY_TRADE_PAY_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pollCtx.Done():
+			a.tradeCancel(ctx, outTradeNo)
+			return nil, fmt.Errorf("alipay trade pay timeout, out_trade_no: %s", outTradeNo)
+		case <-ticker.C:
+		}
+
+		queryRes, err := a.client.TradeQuery(pollCtx, alipay.TradeQuery{OutTradeNo: outTradeNo})
+		if err != nil {
+			a.logger.Error(ctx, "alipay TradeQuery error: ", err.Error())
+			continue
+		}
+		if queryRes.IsFailure() {
+			a.logger.Error(ctx, "alipay TradeQuery error: ", queryRes.Error.Error())
+			continue
+		}
+		switch queryRes.TradeStatus {
+		case alipay.TradeStatusSuccess, alipay.TradeStatusFinished:
+			return &TradePreCreateRes{
+				OutTradeNo: outTradeNo,
+				Extra: AlipayExtraForTradePayRes{
+					TradeNo:        queryRes.TradeNo,
+					BuyerLogonId:   queryRes.BuyerLogonId,
+					BuyerPayAmount: queryRes.BuyerPayAmount,
+					GmtPayment:     queryRes.SendPayDate,
+				},
+			}, nil
+		case alipay.TradeStatusClosed:
+			return nil, fmt.Errorf("alipay trade closed, out_trade_no: %s", outTradeNo)
+		}
+	}
+}
+
+// tradeCancel 撤销交易，原 ctx 可能已超时，使用独立的超时时间
+func (a *AlipayClient) tradeCancel(ctx context.Context, outTradeNo string) {
+	cancelCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), _ALIPAY_TRADE_CANCEL_TIMEOUT)
+	defer cancel()
+
+	cancelRes, err := a.client.TradeCancel(cancelCtx, alipay.TradeCancel{OutTradeNo: outTradeNo})
+	if err != nil {
+		a.logger.Error(ctx, "alipay TradeCancel error: ", err.Error())
+		return
+	}
+	if cancelRes.IsFailure() {
+		a.logger.Error(ctx, "alipay TradeCancel error: ", cancelRes.Error.Error())
+		return
+	}
+	a.logger.Debugf(ctx, "alipay TradeCancel OutTradeNo: %s, action: %s", outTradeNo, cancelRes.Action)
+}
+
 func (a *AlipayClient) Notify(w http.ResponseWriter, req *http.Request) {
 	ctx := gctx.New()
 	err := req.ParseForm()
@@ -100,11 +307,51 @@ func (a *AlipayClient) Notify(w http.ResponseWriter, req *http.Request) {
 	}
 	alipay.ACKNotification(w)
 	// a.logger.Debug(ctx, "alipay notifyReq: ", noti)
-	a.logger.Debug(ctx, "alipay notifyReq OutTradeNo: ", noti.OutTradeNo)
-	a.fulfillCheckout(noti.OutTradeNo)
+	a.logger.Debug(ctx, "alipay notifyReq OutTradeNo: ", noti.OutTradeNo, ", TradeStatus: ", noti.TradeStatus)
+	// 仅支付成功的通知履约，交易关闭及等待付款的通知不履约
+	if noti.TradeStatus == alipay.TradeStatusSuccess || noti.TradeStatus == alipay.TradeStatusFinished {
+		a.fulfillCheckout(noti.OutTradeNo)
+	}
+	a.emitEvent(noti)
 	return
 }
 
+// SetEventHandler 设置异步通知事件处理函数，事件中携带回传参数等信息
+func (a *AlipayClient) SetEventHandler(handler func(*NotifyEvent)) {
+	a.eventHandler = handler
+}
+
+func (a *AlipayClient) emitEvent(noti *alipay.Notification) {
+	if a.eventHandler == nil {
+		return
+	}
+	var eventType NotifyEventType
+	switch noti.TradeStatus {
+	case alipay.TradeStatusSuccess, alipay.TradeStatusFinished:
+		eventType = NOTIFY_EVENT_PAID
+	case alipay.TradeStatusClosed:
+		eventType = NOTIFY_EVENT_CLOSED
+	default:
+		return
+	}
+	passback, err := url.QueryUnescape(noti.PassbackParams)
+	if err != nil {
+		passback = noti.PassbackParams
+	}
+	a.eventHandler(&NotifyEvent{
+		PaymentKey:  a.config.PaymentKey,
+		PaymentType: PAYMENT_TYPE_ALIPAY,
+		Type:        eventType,
+		OutTradeNo:  noti.OutTradeNo,
+		TradeNo:     noti.TradeNo,
+		Extra: AlipayExtraForNotifyEvent{
+			PassbackParams: passback,
+			TotalAmount:    noti.TotalAmount,
+			BuyerPayAmount: noti.BuyerPayAmount,
+		},
+	})
+}
+
 func (a *AlipayClient) SetDebug(debug bool) {
 	a.logger.SetDebug(debug)
 }
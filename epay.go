@@ -4,8 +4,16 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gogf/gf/v2/crypto/gmd5"
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/os/gcache"
 	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/gogf/gf/v2/os/glog"
 )
@@ -18,6 +26,33 @@ const (
 	_EPAY_LOG_LEVEL     = "error"
 	_EPAY_TRADE_SUCCESS = "TRADE_SUCCESS"
 	_EPAY_SUCCESS_RESP  = "success"
+	_EPAY_FAIL_RESP     = "fail"
+	_EPAY_ORDER_TIMEOUT = 24 * time.Hour // 预创建订单缓存时间，用于异步通知时校验金额
+	_EPAY_FULFILLED_KEY = "fulfilled:"   // 已履约订单标记的缓存 key 前缀，重复通知直接应答 success
+	_EPAY_SIGN_TYPE_MD5 = "MD5"
+	_EPAY_MAPI_PATH     = "mapi.php" // API 接口支付，与 submit.php 位于同一目录
+	_EPAY_API_PATH      = "api.php"  // 订单查询、退款等接口，与 submit.php 位于同一目录
+	_EPAY_ACT_ORDER     = "order"
+	_EPAY_ACT_REFUND    = "refund"
+	_EPAY_STATUS_PAID   = 1 // 订单查询：已支付
+	_EPAY_API_SUCCESS   = 1
+)
+
+// EpayMode 下单模式
+type EpayMode string
+
+const (
+	EPAY_MODE_SUBMIT EpayMode = ""    // 页面跳转支付(submit.php)，返回跳转链接
+	EPAY_MODE_API    EpayMode = "api" // API 接口支付(mapi.php)，服务端下单，返回支付链接或二维码
+)
+
+// EpayPayType 支付方式
+type EpayPayType string
+
+const (
+	EPAY_PAY_TYPE_ALIPAY EpayPayType = "alipay" // 支付宝
+	EPAY_PAY_TYPE_WXPAY  EpayPayType = "wxpay"  // 微信支付
+	EPAY_PAY_TYPE_QQPAY  EpayPayType = "qqpay"  // QQ钱包
 )
 
 type EpayConfig struct {
@@ -27,11 +62,85 @@ type EpayConfig struct {
 	Pid        string
 	NotifyURL  string
 	ReturnURL  string
+	Mode       EpayMode // 下单模式，默认为页面跳转支付，仅 V1 协议有效
+
+	Version           EpayVersion // 接口协议版本，默认为 V1(MD5 签名)
+	PrivateKey        string      // V2 协议商户私钥，用于请求签名
+	PlatformPublicKey string      // V2 协议平台公钥，用于校验响应及异步通知签名
+}
+
+type EpayExtraForTradePreCreateReq struct {
+	Type     EpayPayType `json:"type"`     // 支付方式，为空时跳转至收银台由用户选择
+	SiteName string      `json:"sitename"` // 网站名称
+	Param    string      `json:"param"`    // 业务扩展参数，支付后原样返回
+	ClientIP string      `json:"clientip"` // 用户发起支付的IP地址，API 接口支付必填
+	Device   string      `json:"device"`   // 设备类型(pc, mobile, qq, wechat, alipay)，API 接口支付使用
+	Method   string      `json:"method"`   // V2 协议接口类型(web, jump, jsapi, app, scan, applet)，默认为 web
+}
+
+type EpayExtraForTradePreCreateRes struct {
+	TradeNo   string `json:"tradeNo"`   // 支付平台订单号
+	PayURL    string `json:"payURL"`    // 支付跳转链接
+	QRCode    string `json:"qrCode"`    // 二维码链接
+	URLScheme string `json:"urlScheme"` // 小程序跳转链接
+	PayType   string `json:"payType"`   // V2 协议发起支付类型
+	PayInfo   string `json:"payInfo"`   // V2 协议发起支付参数
+}
+
+type EpayTradeQueryRes struct {
+	OutTradeNo  string      `json:"outTradeNo"`  // 内部订单系统编号
+	TradeNo     string      `json:"tradeNo"`     // 支付平台订单号
+	ApiTradeNo  string      `json:"apiTradeNo"`  // 上游支付渠道订单号
+	Type        EpayPayType `json:"type"`        // 支付方式
+	Status      TradeStatus `json:"status"`      // 交易状态
+	Money       string      `json:"money"`       // 订单金额，单位为元
+	RefundMoney string      `json:"refundMoney"` // 已退款金额，单位为元
+	Param       string      `json:"param"`       // 业务扩展参数
+	Buyer       string      `json:"buyer"`       // 支付用户标识
+	AddTime     string      `json:"addTime"`     // 创建时间
+	EndTime     string      `json:"endTime"`     // 完成时间
+}
+
+type EpayTradeRefundRes struct {
+	OutTradeNo  string `json:"outTradeNo"`  // 内部订单系统编号
+	TradeNo     string `json:"tradeNo"`     // 支付平台订单号
+	RefundNo    string `json:"refundNo"`    // 支付平台退款单号，仅 V2 协议返回
+	RefundMoney string `json:"refundMoney"` // 本次退款金额，单位为元
+	Msg         string `json:"msg"`         // 支付平台返回信息
+}
+
+// epayApiRes api.php 响应结构体
+type epayApiRes struct {
+	Code       int    `json:"code"` // 1 为成功，其它值为失败
+	Msg        string `json:"msg"`
+	TradeNo    string `json:"trade_no"`
+	OutTradeNo string `json:"out_trade_no"`
+	ApiTradeNo string `json:"api_trade_no"`
+	Type       string `json:"type"`
+	Status     int    `json:"status"` // 0 未支付，1 已支付
+	Money      string `json:"money"`
+	Param      string `json:"param"`
+	Buyer      string `json:"buyer"`
+	AddTime    string `json:"addtime"`
+	EndTime    string `json:"endtime"`
+}
+
+// epayMapiRes API 接口支付响应结构体
+type epayMapiRes struct {
+	Code      int    `json:"code"` // 1 为成功，其它值为失败
+	Msg       string `json:"msg"`
+	TradeNo   string `json:"trade_no"`
+	PayURL    string `json:"payurl"`
+	QRCode    string `json:"qrcode"`
+	URLScheme string `json:"urlscheme"`
 }
 
 type EpayClient struct {
 	config          EpayConfig
 	logger          *glog.Logger
+	httpClient      *gclient.Client
+	cache           *gcache.Cache // 缓存：key=订单号 value=订单金额，key=_EPAY_FULFILLED_KEY+订单号 value=已履约标记
+	mu              sync.Mutex    // 保证同一订单只履约一次
 	fulfillCheckout func(string)
 }
 
@@ -46,11 +155,14 @@ func NewEpayClient(config EpayConfig, fulfillCheckout func(string)) (*EpayClient
 	return &EpayClient{
 		config:          config,
 		logger:          l,
+		httpClient:      gclient.New(),
+		cache:           gcache.New(),
 		fulfillCheckout: fulfillCheckout,
 	}, nil
 }
 
-// TradePrecreate 创建 epay 订单，页面跳转支付，方法：GET
+// TradePrecreate 创建 epay 订单，默认为页面跳转支付(方法：GET)，EPAY_MODE_API 模式下为 API 接口支付(方法：POST)，
+// V2 协议使用统一下单接口(方法：POST)
 func (e *EpayClient) TradePrecreate(ctx context.Context, req *TradePreCreateReq) (res *TradePreCreateRes, err error) {
 	// 处理汇率
 	amount, err := ERInstance.ConvertToStandard(ctx, req.TotalAmount, req.Currency, CurrencyCNY)
@@ -60,25 +172,200 @@ func (e *EpayClient) TradePrecreate(ctx context.Context, req *TradePreCreateReq)
 	if amount < 0.01 {
 		amount = 0.01
 	}
-	payURL := fmt.Sprintf(
-		"money=%s&name=%s&notify_url=%s&out_trade_no=%s&pid=%s&return_url=%s",
-		fmt.Sprintf("%.2f", amount),
-		req.ProductSubject,
-		e.config.NotifyURL,
-		req.OutTradeNo,
-		e.config.Pid,
-		e.config.ReturnURL,
-	)
-	sign := gmd5.MustEncryptString(payURL + e.config.Key)
-	payURL = fmt.Sprintf("%s?%s&sign=%s&sign_type=MD5", e.config.Url, payURL, sign)
-
-	e.logger.Debugf(ctx, "epay pay url: %s", payURL)
-
-	return &TradePreCreateRes{
+	money := fmt.Sprintf("%.2f", amount)
+	params := map[string]string{
+		"pid":          e.config.Pid,
+		"out_trade_no": req.OutTradeNo,
+		"notify_url":   e.config.NotifyURL,
+		"return_url":   e.config.ReturnURL,
+		"name":         req.ProductSubject,
+		"money":        money,
+	}
+	if ex, ok := req.Extra.(EpayExtraForTradePreCreateReq); ok {
+		params["type"] = string(ex.Type)
+		params["sitename"] = ex.SiteName
+		params["param"] = ex.Param
+		if e.config.Mode == EPAY_MODE_API || e.config.Version == EPAY_VERSION_V2 {
+			params["clientip"] = ex.ClientIP
+			params["device"] = ex.Device
+		}
+		if e.config.Version == EPAY_VERSION_V2 {
+			params["method"] = ex.Method
+		}
+	}
+
+	res = &TradePreCreateRes{
 		OutTradeNo: req.OutTradeNo,
-		PayURL:     payURL,
+	}
+	if e.config.Mode == EPAY_MODE_API || e.config.Version == EPAY_VERSION_V2 {
+		var ex *EpayExtraForTradePreCreateRes
+		if e.config.Version == EPAY_VERSION_V2 {
+			delete(params, "sitename") // V2 协议无该参数
+			ex, err = e.v2Create(ctx, params)
+		} else {
+			ex, err = e.mapi(ctx, params)
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.Extra = *ex
+		// 优先返回支付跳转链接，其次为二维码链接、小程序跳转链接
+		switch {
+		case ex.PayURL != "":
+			res.PayURL = ex.PayURL
+		case ex.QRCode != "":
+			res.PayURL = ex.QRCode
+		default:
+			res.PayURL = ex.URLScheme
+		}
+	} else {
+		res.PayURL = e.config.Url + "?" + epaySignedValues(params, e.config.Key).Encode()
+	}
+
+	e.logger.Debugf(ctx, "epay pay url: %s", res.PayURL)
+
+	// 缓存订单金额，异步通知时校验
+	err = e.cache.Set(ctx, req.OutTradeNo, money, _EPAY_ORDER_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+
+}
+
+// mapi API 接口支付，方法：POST
+func (e *EpayClient) mapi(ctx context.Context, params map[string]string) (*EpayExtraForTradePreCreateRes, error) {
+	apiURL, err := e.endpoint(_EPAY_MAPI_PATH)
+	if err != nil {
+		return nil, err
+	}
+	var mapiRes *epayMapiRes
+	err = e.httpClient.ContentType("application/x-www-form-urlencoded").
+		PostVar(ctx, apiURL, epaySignedValues(params, e.config.Key).Encode()).
+		Scan(&mapiRes)
+	if err != nil {
+		return nil, err
+	}
+	if mapiRes == nil {
+		return nil, fmt.Errorf("epay mapi response null, out_trade_no: %s", params["out_trade_no"])
+	}
+	if mapiRes.Code != _EPAY_API_SUCCESS {
+		return nil, fmt.Errorf("epay mapi error, code: %d, msg: %s", mapiRes.Code, mapiRes.Msg)
+	}
+	return &EpayExtraForTradePreCreateRes{
+		TradeNo:   mapiRes.TradeNo,
+		PayURL:    mapiRes.PayURL,
+		QRCode:    mapiRes.QRCode,
+		URLScheme: mapiRes.URLScheme,
+	}, nil
+}
+
+// TradeQuery 查询订单，可用于异步通知丢失时主动轮询支付结果
+func (e *EpayClient) TradeQuery(ctx context.Context, outTradeNo string) (*EpayTradeQueryRes, error) {
+	if e.config.Version == EPAY_VERSION_V2 {
+		return e.v2Query(ctx, outTradeNo)
+	}
+
+	apiURL, err := e.endpoint(_EPAY_API_PATH)
+	if err != nil {
+		return nil, err
+	}
+	var apiRes *epayApiRes
+	err = e.httpClient.GetVar(ctx, apiURL, map[string]string{
+		"act":          _EPAY_ACT_ORDER,
+		"pid":          e.config.Pid,
+		"key":          e.config.Key,
+		"out_trade_no": outTradeNo,
+	}).Scan(&apiRes)
+	if err != nil {
+		return nil, err
+	}
+	if apiRes == nil {
+		return nil, fmt.Errorf("epay query response null, out_trade_no: %s", outTradeNo)
+	}
+	if apiRes.Code != _EPAY_API_SUCCESS {
+		return nil, fmt.Errorf("epay query error, code: %d, msg: %s", apiRes.Code, apiRes.Msg)
+	}
+	res := &EpayTradeQueryRes{
+		OutTradeNo: apiRes.OutTradeNo,
+		TradeNo:    apiRes.TradeNo,
+		ApiTradeNo: apiRes.ApiTradeNo,
+		Type:       EpayPayType(apiRes.Type),
+		Status:     TRADE_STATUS_WAIT_PAY,
+		Money:      apiRes.Money,
+		Param:      apiRes.Param,
+		Buyer:      apiRes.Buyer,
+		AddTime:    apiRes.AddTime,
+		EndTime:    apiRes.EndTime,
+	}
+	if apiRes.Status == _EPAY_STATUS_PAID {
+		res.Status = TRADE_STATUS_PAID
+	}
+	return res, nil
+}
+
+// TradeRefund 订单退款，refundAmount 为退款金额，单位为人民币分
+func (e *EpayClient) TradeRefund(ctx context.Context, outTradeNo string, refundAmount int64) (*EpayTradeRefundRes, error) {
+	money := fmt.Sprintf("%.2f", MinorToStandardUnit(refundAmount, CurrencyCNY))
+	if e.config.Version == EPAY_VERSION_V2 {
+		return e.v2Refund(ctx, outTradeNo, money)
+	}
+
+	apiURL, err := e.endpoint(_EPAY_API_PATH)
+	if err != nil {
+		return nil, err
+	}
+	var apiRes *epayApiRes
+	err = e.httpClient.ContentType("application/x-www-form-urlencoded").
+		PostVar(ctx, apiURL+"?act="+_EPAY_ACT_REFUND, url.Values{
+			"pid":          {e.config.Pid},
+			"key":          {e.config.Key},
+			"out_trade_no": {outTradeNo},
+			"money":        {money},
+		}.Encode()).
+		Scan(&apiRes)
+	if err != nil {
+		return nil, err
+	}
+	if apiRes == nil {
+		return nil, fmt.Errorf("epay refund response null, out_trade_no: %s", outTradeNo)
+	}
+	if apiRes.Code != _EPAY_API_SUCCESS {
+		return nil, fmt.Errorf("epay refund error, code: %d, msg: %s", apiRes.Code, apiRes.Msg)
+	}
+	return &EpayTradeRefundRes{
+		OutTradeNo:  outTradeNo,
+		TradeNo:     apiRes.TradeNo,
+		RefundMoney: money,
+		Msg:         apiRes.Msg,
 	}, nil
+}
 
+// TradeSync 主动查询订单，已支付且金额校验通过时履约订单，用于异步通知丢失时轮询支付结果
+//
+// 与异步通知共用履约标记，同一订单只会履约一次，已履约的订单仍返回查询到的支付状态
+func (e *EpayClient) TradeSync(ctx context.Context, outTradeNo string) (*EpayTradeQueryRes, error) {
+	res, err := e.TradeQuery(ctx, outTradeNo)
+	if err != nil {
+		return nil, err
+	}
+	if res.Status != TRADE_STATUS_PAID {
+		return res, nil
+	}
+	if err = e.checkMoney(ctx, outTradeNo, res.Money); err != nil {
+		return nil, err
+	}
+	e.fulfill(ctx, outTradeNo)
+	return res, nil
+}
+
+// endpoint 根据页面跳转支付 url 获取同目录下其它接口的地址，如 mapi.php, api.php
+func (e *EpayClient) endpoint(path string) (string, error) {
+	u, err := url.Parse(e.config.Url)
+	if err != nil {
+		return "", err
+	}
+	return u.ResolveReference(&url.URL{Path: path}).String(), nil
 }
 
 // Notify 接收epay的异步通知，方法：GET，收到异步通知后，需返回success以表示服务器接收到了订单通知
@@ -95,14 +382,143 @@ func (e *EpayClient) Notify(w http.ResponseWriter, req *http.Request) {
 
 	e.logger.Debugf(ctx, "out_trade_no: %v, trade_status: %s", no, status)
 
+	err = e.verifyNotify(ctx, req.Form)
+	if err != nil {
+		e.logger.Error(ctx, "epay verify notify error: ", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(_EPAY_FAIL_RESP))
+		return
+	}
+
 	if status == _EPAY_TRADE_SUCCESS {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(_EPAY_SUCCESS_RESP))
-		e.fulfillCheckout(no)
+		e.fulfill(ctx, no)
 		return
 	}
 	return
 }
+
+// fulfill 标记订单已履约并履约订单，已有履约标记时跳过，避免异步通知重发或轮询时重复履约
+//
+// 履约标记保存在内存中，进程重启后重复的通知会再次回调 fulfillCheckout，业务方需保证履约幂等
+func (e *EpayClient) fulfill(ctx context.Context, outTradeNo string) {
+	ok, err := e.markFulfilled(ctx, outTradeNo)
+	if err != nil {
+		e.logger.Error(ctx, "epay mark order fulfilled error: ", err.Error())
+		return
+	}
+	if !ok {
+		e.logger.Debugf(ctx, "epay order already fulfilled, out_trade_no: %s", outTradeNo)
+		return
+	}
+	e.fulfillCheckout(outTradeNo)
+}
+
+// markFulfilled 设置履约标记并释放订单金额缓存，已有标记时返回 false
+func (e *EpayClient) markFulfilled(ctx context.Context, outTradeNo string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := _EPAY_FULFILLED_KEY + outTradeNo
+	done, err := e.cache.Contains(ctx, key)
+	if err != nil || done {
+		return false, err
+	}
+	if err = e.cache.Set(ctx, key, true, _EPAY_ORDER_TIMEOUT); err != nil {
+		return false, err
+	}
+	_, err = e.cache.Remove(ctx, outTradeNo)
+	return true, err
+}
+
+// verifyNotify 校验异步通知的签名、商户ID以及订单金额
+func (e *EpayClient) verifyNotify(ctx context.Context, form url.Values) error {
+	params := make(map[string]string, len(form))
+	for k := range form {
+		params[k] = form.Get(k)
+	}
+	if e.config.Version == EPAY_VERSION_V2 {
+		if err := epayV2Verify(params, e.config.PlatformPublicKey); err != nil {
+			return fmt.Errorf("invalid sign, out_trade_no: %s, %w", params["out_trade_no"], err)
+		}
+	} else if sign := epaySign(params, e.config.Key); !strings.EqualFold(sign, params["sign"]) {
+		return fmt.Errorf("invalid sign, out_trade_no: %s", params["out_trade_no"])
+	}
+	if params["pid"] != e.config.Pid {
+		return fmt.Errorf("invalid pid: %s, out_trade_no: %s", params["pid"], params["out_trade_no"])
+	}
+
+	return e.checkMoney(ctx, params["out_trade_no"], params["money"])
+}
+
+// checkMoney 校验支付金额与预创建订单金额是否一致
+//
+// 订单缓存不存在时(已履约或进程重启后丢失)跳过校验，以签名校验通过的通知及查询结果为准
+func (e *EpayClient) checkMoney(ctx context.Context, outTradeNo string, moneyStr string) error {
+	va, err := e.cache.Get(ctx, outTradeNo)
+	if err != nil {
+		return err
+	}
+	if va == nil {
+		e.logger.Debugf(ctx, "epay order cache not found, skip money check, out_trade_no: %s", outTradeNo)
+		return nil
+	}
+	expected, err := strconv.ParseFloat(va.String(), 64)
+	if err != nil {
+		return err
+	}
+	money, err := strconv.ParseFloat(moneyStr, 64)
+	if err != nil {
+		return fmt.Errorf("invalid money: %s, out_trade_no: %s", moneyStr, outTradeNo)
+	}
+	// 金额精确到分
+	if StandardToMinorUnit(money, CurrencyCNY) != StandardToMinorUnit(expected, CurrencyCNY) {
+		return fmt.Errorf("money mismatch, expected: %s, got: %s, out_trade_no: %s", va.String(), moneyStr, outTradeNo)
+	}
+	return nil
+}
+
+// epaySignedValues 过滤空值参数并追加签名，返回可直接编码的请求参数
+func epaySignedValues(params map[string]string, key string) url.Values {
+	values := make(url.Values, len(params)+2)
+	for k, v := range params {
+		if v != "" {
+			values.Set(k, v)
+		}
+	}
+	values.Set("sign", epaySign(params, key))
+	values.Set("sign_type", _EPAY_SIGN_TYPE_MD5)
+	return values
+}
+
+// epaySign 计算 epay MD5 签名：待签名字符串追加商户密钥后进行 MD5 加密
+func epaySign(params map[string]string, key string) string {
+	return gmd5.MustEncryptString(epaySignContent(params) + key)
+}
+
+// epaySignContent 拼接待签名字符串：参数按参数名 ASCII 码从小到大排序，sign、sign_type 和空值不参与签名，
+// 拼接成 a=b&c=d 的格式
+func epaySignContent(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if k == "sign" || k == "sign_type" || v == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte('&')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(params[k])
+	}
+	return sb.String()
+}
 func (e *EpayClient) SetDebug(debug bool) {
 	e.logger.SetDebug(debug)
 }
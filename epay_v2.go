@@ -0,0 +1,262 @@
+package paykit
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+const (
+	_EPAY_V2_CREATE_PATH   = "api/pay/create" // V2 统一下单，相对于 EpayConfig.Url 所在目录
+	_EPAY_V2_QUERY_PATH    = "api/pay/query"  // V2 订单查询
+	_EPAY_V2_REFUND_PATH   = "api/pay/refund" // V2 订单退款
+	_EPAY_V2_SIGN_TYPE     = "RSA"
+	_EPAY_V2_API_SUCCESS   = 0
+	_EPAY_V2_METHOD_WEB    = "web" // 通用网页支付，根据 device 返回跳转链接或二维码
+	_EPAY_V2_STATUS_PAID   = 1     // 订单查询：已支付
+	_EPAY_V2_PAY_TYPE_JUMP = "jump"
+	_EPAY_V2_PAY_TYPE_QR   = "qrcode"
+	_EPAY_V2_PAY_TYPE_URLS = "urlscheme"
+)
+
+// EpayVersion 接口协议版本
+type EpayVersion string
+
+const (
+	EPAY_VERSION_V1 EpayVersion = ""   // V1 协议，MD5 签名
+	EPAY_VERSION_V2 EpayVersion = "v2" // V2 协议，SHA256WithRSA 签名
+)
+
+// epayV2Res V2 接口公共响应结构体
+type epayV2Res struct {
+	Code      int    `json:"code"` // 0 为成功，其它值为失败
+	Msg       string `json:"msg"`
+	TradeNo   string `json:"trade_no"`
+	PayType   string `json:"pay_type"` // 发起支付类型：jump, html, qrcode, urlscheme, jsapi, app, scan, wxplugin, wxapp
+	PayInfo   string `json:"pay_info"` // 发起支付参数，根据 pay_type 不同而不同
+	Timestamp string `json:"timestamp"`
+}
+
+// epayV2QueryRes V2 订单查询响应结构体
+type epayV2QueryRes struct {
+	Code        int    `json:"code"`
+	Msg         string `json:"msg"`
+	TradeNo     string `json:"trade_no"`
+	OutTradeNo  string `json:"out_trade_no"`
+	ApiTradeNo  string `json:"api_trade_no"`
+	Type        string `json:"type"`
+	Status      int    `json:"status"` // 0 未支付，1 已支付
+	Money       string `json:"money"`
+	RefundMoney string `json:"refundmoney"`
+	Param       string `json:"param"`
+	Buyer       string `json:"buyer"`
+	AddTime     string `json:"addtime"`
+	EndTime     string `json:"endtime"`
+}
+
+// v2Create V2 统一下单，方法：POST
+func (e *EpayClient) v2Create(ctx context.Context, params map[string]string) (*EpayExtraForTradePreCreateRes, error) {
+	if params["method"] == "" {
+		params["method"] = _EPAY_V2_METHOD_WEB
+	}
+	var createRes *epayV2Res
+	err := e.v2Request(ctx, _EPAY_V2_CREATE_PATH, params, &createRes)
+	if err != nil {
+		return nil, err
+	}
+	ex := &EpayExtraForTradePreCreateRes{
+		TradeNo: createRes.TradeNo,
+		PayType: createRes.PayType,
+		PayInfo: createRes.PayInfo,
+	}
+	switch createRes.PayType {
+	case _EPAY_V2_PAY_TYPE_JUMP:
+		ex.PayURL = createRes.PayInfo
+	case _EPAY_V2_PAY_TYPE_QR:
+		ex.QRCode = createRes.PayInfo
+	case _EPAY_V2_PAY_TYPE_URLS:
+		ex.URLScheme = createRes.PayInfo
+	}
+	return ex, nil
+}
+
+// v2Query V2 订单查询
+func (e *EpayClient) v2Query(ctx context.Context, outTradeNo string) (*EpayTradeQueryRes, error) {
+	var queryRes *epayV2QueryRes
+	err := e.v2Request(ctx, _EPAY_V2_QUERY_PATH, map[string]string{"out_trade_no": outTradeNo}, &queryRes)
+	if err != nil {
+		return nil, err
+	}
+	res := &EpayTradeQueryRes{
+		OutTradeNo:  queryRes.OutTradeNo,
+		TradeNo:     queryRes.TradeNo,
+		ApiTradeNo:  queryRes.ApiTradeNo,
+		Type:        EpayPayType(queryRes.Type),
+		Status:      TRADE_STATUS_WAIT_PAY,
+		Money:       queryRes.Money,
+		RefundMoney: queryRes.RefundMoney,
+		Param:       queryRes.Param,
+		Buyer:       queryRes.Buyer,
+		AddTime:     queryRes.AddTime,
+		EndTime:     queryRes.EndTime,
+	}
+	if queryRes.Status == _EPAY_V2_STATUS_PAID {
+		res.Status = TRADE_STATUS_PAID
+	}
+	return res, nil
+}
+
+// epayV2RefundRes V2 订单退款响应结构体
+type epayV2RefundRes struct {
+	Code        int    `json:"code"`
+	Msg         string `json:"msg"`
+	RefundNo    string `json:"refund_no"`
+	OutRefundNo string `json:"out_refund_no"`
+	TradeNo     string `json:"trade_no"`
+	Money       string `json:"money"`
+}
+
+// v2Refund V2 订单退款
+func (e *EpayClient) v2Refund(ctx context.Context, outTradeNo string, money string) (*EpayTradeRefundRes, error) {
+	var refundRes *epayV2RefundRes
+	err := e.v2Request(ctx, _EPAY_V2_REFUND_PATH, map[string]string{
+		"out_trade_no": outTradeNo,
+		"money":        money,
+	}, &refundRes)
+	if err != nil {
+		return nil, err
+	}
+	return &EpayTradeRefundRes{
+		OutTradeNo:  outTradeNo,
+		TradeNo:     refundRes.TradeNo,
+		RefundNo:    refundRes.RefundNo,
+		RefundMoney: refundRes.Money,
+		Msg:         refundRes.Msg,
+	}, nil
+}
+
+// v2Request 发起 V2 接口请求，校验响应状态码及平台签名后解析到 result
+func (e *EpayClient) v2Request(ctx context.Context, path string, params map[string]string, result any) error {
+	apiURL, err := e.endpoint(path)
+	if err != nil {
+		return err
+	}
+	params["pid"] = e.config.Pid
+	params["timestamp"] = strconv.FormatInt(time.Now().Unix(), 10)
+	values, err := epayV2SignedValues(params, e.config.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.httpClient.ContentType("application/x-www-form-urlencoded").Post(ctx, apiURL, values.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	body := resp.ReadAll()
+	e.logger.Debugf(ctx, "epay v2 %s response: %s", path, body)
+
+	var raw map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err = decoder.Decode(&raw); err != nil {
+		return fmt.Errorf("epay v2 %s invalid response: %w", path, err)
+	}
+	if code := gconv.Int(raw["code"]); code != _EPAY_V2_API_SUCCESS {
+		return fmt.Errorf("epay v2 %s error, code: %d, msg: %s", path, code, gconv.String(raw["msg"]))
+	}
+	resParams := make(map[string]string, len(raw))
+	for k, v := range raw {
+		resParams[k] = gconv.String(v)
+	}
+	if err = epayV2Verify(resParams, e.config.PlatformPublicKey); err != nil {
+		return fmt.Errorf("epay v2 %s verify response error: %w", path, err)
+	}
+	return gconv.Scan(raw, result)
+}
+
+// epayV2SignedValues 过滤空值参数并追加 RSA 签名
+func epayV2SignedValues(params map[string]string, privateKey string) (url.Values, error) {
+	key, err := parseRSAPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	hashed := sha256.Sum256([]byte(epaySignContent(params)))
+	sign, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(url.Values, len(params)+2)
+	for k, v := range params {
+		if v != "" {
+			values.Set(k, v)
+		}
+	}
+	values.Set("sign", base64.StdEncoding.EncodeToString(sign))
+	values.Set("sign_type", _EPAY_V2_SIGN_TYPE)
+	return values, nil
+}
+
+// epayV2Verify 使用平台公钥校验 V2 签名，待签名字符串的拼接规则与 V1 相同
+func epayV2Verify(params map[string]string, publicKey string) error {
+	key, err := parseRSAPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	sign, err := base64.StdEncoding.DecodeString(params["sign"])
+	if err != nil {
+		return fmt.Errorf("invalid sign: %w", err)
+	}
+	hashed := sha256.Sum256([]byte(epaySignContent(params)))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sign)
+}
+
+// parseRSAPrivateKey 解析商户私钥，支持 PEM 格式及不带头尾的 base64 格式，PKCS8 或 PKCS1
+func parseRSAPrivateKey(s string) (*rsa.PrivateKey, error) {
+	der, err := decodeKey(s)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+			return rsaKey, nil
+		}
+		return nil, fmt.Errorf("private key is not RSA")
+	}
+	return x509.ParsePKCS1PrivateKey(der)
+}
+
+// parseRSAPublicKey 解析平台公钥，支持 PEM 格式及不带头尾的 base64 格式，PKIX 或 PKCS1
+func parseRSAPublicKey(s string) (*rsa.PublicKey, error) {
+	der, err := decodeKey(s)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		if rsaKey, ok := key.(*rsa.PublicKey); ok {
+			return rsaKey, nil
+		}
+		return nil, fmt.Errorf("public key is not RSA")
+	}
+	return x509.ParsePKCS1PublicKey(der)
+}
+
+func decodeKey(s string) ([]byte, error) {
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		return block.Bytes, nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
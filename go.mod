@@ -3,9 +3,11 @@ module github.com/ppoonk/paykit
 go 1.25.1
 
 require (
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.4.0
 	github.com/gogf/gf/v2 v2.9.1
 	github.com/smartwalle/alipay/v3 v3.2.27
 	github.com/stripe/stripe-go/v82 v82.4.1
+	golang.org/x/crypto v0.41.0
 )
 
 require (
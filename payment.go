@@ -41,6 +41,49 @@ type TradePreCreateRes struct {
 	Extra      any    `json:"extra"`        // 扩展参数
 }
 
+// TradeStatus 归一化的交易状态
+type TradeStatus string
+
+const (
+	TRADE_STATUS_WAIT_PAY TradeStatus = "WAIT_PAY" // 等待付款
+	TRADE_STATUS_PAID     TradeStatus = "PAID"     // 支付成功
+)
+
+// NotifyEventType 异步通知事件类型
+type NotifyEventType string
+
+const (
+	NOTIFY_EVENT_PAID    NotifyEventType = "PAID"    // 支付成功
+	NOTIFY_EVENT_CLOSED  NotifyEventType = "CLOSED"  // 交易关闭
+	NOTIFY_EVENT_FAILED  NotifyEventType = "FAILED"  // 支付失败
+	NOTIFY_EVENT_EXPIRED NotifyEventType = "EXPIRED" // 订单过期未支付
+
+	NOTIFY_EVENT_LATE_PAYMENT NotifyEventType = "LATE_PAYMENT" // 订单过期后收到付款，未自动履约
+	NOTIFY_EVENT_UNDERPAID    NotifyEventType = "UNDERPAID"    // 实付金额不足，未自动履约，订单有效期内补足后履约
+	NOTIFY_EVENT_OVERPAID     NotifyEventType = "OVERPAID"     // 实付金额超出应付金额，已履约，超出部分需人工处理
+	NOTIFY_EVENT_UNMATCHED    NotifyEventType = "UNMATCHED"    // 收到无法匹配订单的付款
+
+	NOTIFY_EVENT_AUTHORIZED NotifyEventType = "AUTHORIZED" // 预授权成功，待扣款
+
+	NOTIFY_EVENT_RENEWED   NotifyEventType = "RENEWED"   // 订阅续费成功
+	NOTIFY_EVENT_CANCELLED NotifyEventType = "CANCELLED" // 订阅已取消
+
+	NOTIFY_EVENT_REFUNDED        NotifyEventType = "REFUNDED"        // 订单已退款(含部分退款)
+	NOTIFY_EVENT_REFUND_UPDATED  NotifyEventType = "REFUND_UPDATED"  // 退款状态变更，如退款失败
+	NOTIFY_EVENT_DISPUTE_CREATED NotifyEventType = "DISPUTE_CREATED" // 用户发起争议(拒付)
+	NOTIFY_EVENT_DISPUTE_CLOSED  NotifyEventType = "DISPUTE_CLOSED"  // 争议已结束
+)
+
+// NotifyEvent 归一化的异步通知事件
+type NotifyEvent struct {
+	PaymentKey  any
+	PaymentType PaymentType
+	Type        NotifyEventType
+	OutTradeNo  string // 内部订单系统编号
+	TradeNo     string // 支付平台交易号
+	Extra       any    // 扩展参数
+}
+
 type PayServer struct {
 	payments  sync.Map
 	cancel    context.CancelFunc
@@ -2,12 +2,17 @@ package paykit
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 
 	"net/http"
 	"strings"
 
 	"io"
+	"slices"
+	"time"
 
 	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/gogf/gf/v2/os/glog"
@@ -20,24 +25,230 @@ const (
 	_STRIPE_LOG_TAG   = "[Stripe]"
 	_STRIPE_LOG_PATH  = "./.log/stripe"
 	_STRIPE_LOG_LEVEL = "error"
+
+	_STRIPE_FLOW    = "PAYKIT_FLOW"    // PaymentIntent metadata 中标记创建方式的键
+	_STRIPE_FLOW_PI = "payment_intent" // 由 STRIPE_UI_MODE_ELEMENTS 直接创建的 PaymentIntent
 )
 
+// _STRIPE_DEFAULT_PAYMENT_METHODS 未配置支付方式时默认使用的支付方式
+//
+//	docs:
+//	https://docs.stripe.com/payments/wallets
+var _STRIPE_DEFAULT_PAYMENT_METHODS = []string{"card", "wechat_pay", "alipay"}
+
 type StripeConfig struct {
 	PaymentKey     any
 	StripeKey      string
 	EndpointSecret string
 	SuccessURL     string // 成功回调URL
 	CancelURL      string // 失败回调URL
+	ReturnURL      string // 嵌入式 Checkout 完成后的返回URL，可包含 {CHECKOUT_SESSION_ID} 占位符，为空时使用 SuccessURL
+
+	CheckoutOptions StripeCheckoutOptions // Checkout Session 默认选项，可通过 StripeExtraForTradePreCreateReq 按订单覆盖
+}
+
+// StripeCheckoutOptions Checkout Session 选项，零值表示不设置(使用 Stripe 默认值)
+//
+// PaymentMethodTypes 为空时默认使用 card, wechat_pay, alipay(订阅模式除外)，
+// 设置 DynamicPaymentMethods 后改为使用 Stripe 控制台中启用的动态支付方式
+//
+//	docs:
+//	https://docs.stripe.com/api/checkout/sessions/create
+type StripeCheckoutOptions struct {
+	PaymentMethodTypes       []string `json:"paymentMethodTypes"`       // 支付方式，如 card, wechat_pay, alipay, link, klarna。为空时默认为 card, wechat_pay, alipay
+	DynamicPaymentMethods    bool     `json:"dynamicPaymentMethods"`    // 使用 Stripe 控制台配置的动态支付方式，仅在 PaymentMethodTypes 为空时生效
+	Locale                   string   `json:"locale"`                   // 页面语言，如 auto, en, zh
+	CustomerEmail            string   `json:"customerEmail"`            // 预填客户邮箱
+	ExpiresIn                int      `json:"expiresIn"`                // Checkout Session 过期时间(秒)，自订单创建时间起算，取值范围：1800～86400
+	AllowPromotionCodes      *bool    `json:"allowPromotionCodes"`      // 是否允许使用优惠码
+	AutomaticTax             *bool    `json:"automaticTax"`             // 是否自动计算税费
+	BillingAddressCollection string   `json:"billingAddressCollection"` // 账单地址收集：auto, required
+	CaptureMethod            string   `json:"captureMethod"`            // 扣款方式：automatic, manual(仅预授权，之后调用 Capture 扣款)，订阅模式不支持
+}
+
+// merge 合并选项，o 中的非零值覆盖当前值
+func (c StripeCheckoutOptions) merge(o StripeCheckoutOptions) StripeCheckoutOptions {
+	if o.DynamicPaymentMethods {
+		c.PaymentMethodTypes, c.DynamicPaymentMethods = nil, true
+	}
+	if len(o.PaymentMethodTypes) > 0 {
+		c.PaymentMethodTypes, c.DynamicPaymentMethods = o.PaymentMethodTypes, false
+	}
+	if o.Locale != "" {
+		c.Locale = o.Locale
+	}
+	if o.CustomerEmail != "" {
+		c.CustomerEmail = o.CustomerEmail
+	}
+	if o.ExpiresIn > 0 {
+		c.ExpiresIn = o.ExpiresIn
+	}
+	if o.AllowPromotionCodes != nil {
+		c.AllowPromotionCodes = o.AllowPromotionCodes
+	}
+	if o.AutomaticTax != nil {
+		c.AutomaticTax = o.AutomaticTax
+	}
+	if o.BillingAddressCollection != "" {
+		c.BillingAddressCollection = o.BillingAddressCollection
+	}
+	if o.CaptureMethod != "" {
+		c.CaptureMethod = o.CaptureMethod
+	}
+	return c
+}
+
+// paymentMethods 实际使用的支付方式，返回 nil 时使用 Stripe 控制台配置的动态支付方式
+//
+// 订阅模式不支持微信支付，未配置支付方式时同样使用动态支付方式
+func (c StripeCheckoutOptions) paymentMethods(subscription bool) []string {
+	switch {
+	case len(c.PaymentMethodTypes) > 0:
+		return c.PaymentMethodTypes
+	case c.DynamicPaymentMethods || subscription:
+		return nil
+	default:
+		return _STRIPE_DEFAULT_PAYMENT_METHODS
+	}
+}
+
+// apply 将选项写入 Checkout Session 创建参数，createdAt 为订单创建时间，用于计算 expires_at
+func (c StripeCheckoutOptions) apply(params *stripe.CheckoutSessionCreateParams, createdAt time.Time) {
+	if methods := c.paymentMethods(stripe.StringValue(params.Mode) == string(stripe.CheckoutSessionModeSubscription)); len(methods) > 0 {
+		params.PaymentMethodTypes = stripe.StringSlice(methods)
+		if slices.Contains(methods, "wechat_pay") {
+			params.AddExtra("payment_method_options[wechat_pay][client]", "web")
+		}
+	}
+	if c.Locale != "" {
+		params.Locale = stripe.String(c.Locale)
+	}
+	if c.CustomerEmail != "" {
+		params.CustomerEmail = stripe.String(c.CustomerEmail)
+	}
+	if c.ExpiresIn > 0 {
+		params.ExpiresAt = stripe.Int64(createdAt.Add(time.Duration(c.ExpiresIn) * time.Second).Unix())
+	}
+	params.AllowPromotionCodes = c.AllowPromotionCodes
+	if c.AutomaticTax != nil {
+		params.AutomaticTax = &stripe.CheckoutSessionCreateAutomaticTaxParams{
+			Enabled: c.AutomaticTax,
+		}
+	}
+	if c.BillingAddressCollection != "" {
+		params.BillingAddressCollection = stripe.String(c.BillingAddressCollection)
+	}
+	if c.CaptureMethod != "" && params.PaymentIntentData != nil {
+		params.PaymentIntentData.CaptureMethod = stripe.String(c.CaptureMethod)
+	}
 }
 
 var _ PaymentInterface = (*StripeClient)(nil)
 
+// StripeMode Checkout Session 模式
+type StripeMode string
+
+const (
+	STRIPE_MODE_PAYMENT      StripeMode = "payment"      // 一次性支付
+	STRIPE_MODE_SUBSCRIPTION StripeMode = "subscription" // 订阅，周期性扣款
+)
+
+// StripeUIMode 支付页面形式
+type StripeUIMode string
+
+const (
+	STRIPE_UI_MODE_HOSTED   StripeUIMode = ""         // Stripe 托管的 Checkout 页面，返回跳转链接
+	STRIPE_UI_MODE_EMBEDDED StripeUIMode = "embedded" // 嵌入式 Checkout，返回 Checkout Session 的 client_secret
+	STRIPE_UI_MODE_ELEMENTS StripeUIMode = "elements" // Stripe Elements，直接创建 PaymentIntent 并返回其 client_secret，不支持订阅模式
+)
+
+type StripeExtraForTradePreCreateReq struct {
+	Mode   StripeMode   `json:"mode"`   // Checkout Session 模式，默认为一次性支付
+	UIMode StripeUIMode `json:"uiMode"` // 支付页面形式，默认为托管页面
+
+	// 订阅模式下，PriceID 与内联价格二选一：
+	// 设置 PriceID 时使用 Stripe 中已创建的价格，否则按 TotalAmount、Currency 及计费周期创建内联价格
+	PriceID       string `json:"priceID"`       // Stripe 价格 ID
+	Interval      string `json:"interval"`      // 内联价格计费周期：day, week, month, year，默认为 month
+	IntervalCount int64  `json:"intervalCount"` // 内联价格计费周期数，如 Interval=month、IntervalCount=3 表示每 3 个月扣款一次
+
+	CheckoutOptions StripeCheckoutOptions `json:"checkoutOptions"` // 覆盖 StripeConfig.CheckoutOptions 中的对应选项
+
+	// 订单创建时间，设置 ExpiresIn 时 Checkout Session 于 CreatedAt+ExpiresIn 过期。
+	// 设置 ExpiresIn 且未指定 IdempotencyKey 时必填，重试时传入相同的值，保证 expires_at 及幂等键一致；
+	// 指定 IdempotencyKey 时可为空，此时使用当前时间，由调用方保证重试参数一致
+	CreatedAt time.Time `json:"createdAt"`
+
+	// 幂等键，为空时根据 PaymentKey、OutTradeNo、TotalAmount、Currency 及过期时间生成。
+	// Stripe 幂等键有效期 24 小时，期间相同的键返回同一个 Checkout Session，需重新创建时请指定新的键
+	IdempotencyKey string `json:"idempotencyKey"`
+}
+
+type StripeExtraForRefundDisputeEvent struct {
+	EventID         string `json:"eventID"`         // webhook 事件 ID
+	EventType       string `json:"eventType"`       // webhook 事件类型
+	PaymentIntentID string `json:"paymentIntentID"` // PaymentIntent ID
+	ChargeID        string `json:"chargeID"`        // Charge ID
+	RefundID        string `json:"refundID"`        // Refund ID，仅退款状态变更事件
+	DisputeID       string `json:"disputeID"`       // Dispute ID，仅争议事件
+	Amount          int64  `json:"amount"`          // 退款事件为累计退款金额，退款状态变更事件为本次退款金额，争议事件为争议金额，货币最小单位
+	Currency        string `json:"currency"`        // 货币(小写)
+	Reason          string `json:"reason"`          // 退款或争议原因
+	Status          string `json:"status"`          // 退款或争议状态，如争议结束时的 won, lost
+}
+
+type StripeTradeRefundRes struct {
+	RefundID        string `json:"refundID"`        // Refund ID
+	PaymentIntentID string `json:"paymentIntentID"` // PaymentIntent ID
+	Amount          int64  `json:"amount"`          // 退款金额，货币最小单位
+	Currency        string `json:"currency"`        // 退款货币(小写)
+	Status          string `json:"status"`          // 退款状态：pending, requires_action, succeeded, failed, canceled
+}
+
+type StripeAuthorizationRes struct {
+	PaymentIntentID  string `json:"paymentIntentID"`  // PaymentIntent ID
+	AmountCapturable int64  `json:"amountCapturable"` // 剩余可扣款金额，货币最小单位
+	AmountReceived   int64  `json:"amountReceived"`   // 已扣款金额，货币最小单位
+	Currency         string `json:"currency"`         // 货币(小写)
+	Status           string `json:"status"`           // PaymentIntent 状态：requires_capture, succeeded, canceled 等
+}
+
+type StripeExtraForTradePreCreateRes struct {
+	SessionID       string `json:"sessionID"`       // Checkout Session ID
+	PaymentIntentID string `json:"paymentIntentID"` // PaymentIntent ID，Checkout Session 创建时可能为空
+	ExpiresAt       int64  `json:"expiresAt"`       // Checkout Session 过期时间(秒级时间戳)
+	ClientSecret    string `json:"clientSecret"`    // 嵌入式 Checkout 或 Elements 模式下前端初始化所需的 client_secret
+	ReturnURL       string `json:"returnURL"`       // 嵌入式 Checkout 或 Elements 模式下支付完成后的返回URL
+}
+
+type StripeExtraForNotifyEvent struct {
+	EventID          string `json:"eventID"`          // webhook 事件 ID
+	EventType        string `json:"eventType"`        // webhook 事件类型
+	SessionID        string `json:"sessionID"`        // Checkout Session ID
+	PaymentIntentID  string `json:"paymentIntentID"`  // PaymentIntent ID
+	AmountTotal      int64  `json:"amountTotal"`      // 订单总价，货币最小单位
+	AmountCapturable int64  `json:"amountCapturable"` // 预授权可扣款金额，仅预授权事件
+	Currency         string `json:"currency"`         // 订单总价货币(小写)
+}
+
+type StripeExtraForSubscriptionEvent struct {
+	EventID        string `json:"eventID"`        // webhook 事件 ID
+	EventType      string `json:"eventType"`      // webhook 事件类型
+	SubscriptionID string `json:"subscriptionID"` // Subscription ID
+	InvoiceID      string `json:"invoiceID"`      // Invoice ID，订阅取消事件为空
+	BillingReason  string `json:"billingReason"`  // 账单原因，如 subscription_cycle
+	AmountPaid     int64  `json:"amountPaid"`     // 账单实付金额，货币最小单位
+	Currency       string `json:"currency"`       // 账单货币(小写)
+}
+
 type StripeClient struct {
 	config          StripeConfig
 	client          *stripe.Client
 	endpointSecret  string
 	logger          *glog.Logger
 	fulfillCheckout func(string)
+	eventHandler    func(*NotifyEvent)
+	disputeHandler  func(*NotifyEvent)
 }
 
 func NewStripeClient(config StripeConfig, fulfillCheckout func(string)) (*StripeClient, error) {
@@ -59,14 +270,35 @@ func NewStripeClient(config StripeConfig, fulfillCheckout func(string)) (*Stripe
 
 // TradePrecreate 交易与创建
 func (s *StripeClient) TradePrecreate(ctx context.Context, req *TradePreCreateReq) (res *TradePreCreateRes, err error) {
+	ex, _ := req.Extra.(StripeExtraForTradePreCreateReq)
+	if ex.UIMode == STRIPE_UI_MODE_ELEMENTS {
+		return s.paymentIntentPrecreate(ctx, req, ex)
+	}
+
 	params := &stripe.CheckoutSessionCreateParams{
-		Mode: stripe.String(string(stripe.CheckoutSessionModePayment)),
-		PaymentMethodTypes: stripe.StringSlice([]string{ // https://docs.stripe.com/payments/wallets
-			"card",
-			"wechat_pay",
-			"alipay",
-		}),
-		LineItems: []*stripe.CheckoutSessionCreateLineItemParams{
+		ClientReferenceID: stripe.String(req.OutTradeNo),
+		Metadata: map[string]string{
+			_OUT_TRADE_NO: req.OutTradeNo,
+		},
+	}
+	if ex.UIMode == STRIPE_UI_MODE_EMBEDDED {
+		// 嵌入式 Checkout 不支持 success_url、cancel_url，支付完成后跳转 return_url
+		params.UIMode = stripe.String(string(stripe.CheckoutSessionUIModeEmbedded))
+		params.ReturnURL = stripe.String(s.returnURL())
+	} else {
+		params.SuccessURL = stripe.String(s.config.SuccessURL)
+		params.CancelURL = stripe.String(s.config.CancelURL)
+	}
+	if ex.Mode == STRIPE_MODE_SUBSCRIPTION {
+		s.subscriptionParams(params, req, ex)
+	} else {
+		params.Mode = stripe.String(string(stripe.CheckoutSessionModePayment))
+		params.PaymentIntentData = &stripe.CheckoutSessionCreatePaymentIntentDataParams{
+			Metadata: map[string]string{
+				_OUT_TRADE_NO: req.OutTradeNo,
+			},
+		}
+		params.LineItems = []*stripe.CheckoutSessionCreateLineItemParams{
 			{
 				PriceData: &stripe.CheckoutSessionCreateLineItemPriceDataParams{
 					Currency: stripe.String(strings.ToLower(string(req.Currency))),
@@ -77,26 +309,263 @@ func (s *StripeClient) TradePrecreate(ctx context.Context, req *TradePreCreateRe
 				},
 				Quantity: stripe.Int64(1),
 			},
-		},
-		SuccessURL: stripe.String(s.config.SuccessURL),
-		CancelURL:  stripe.String(s.config.CancelURL),
-		Metadata: map[string]string{
-			_OUT_TRADE_NO: req.OutTradeNo,
-		},
+		}
 	}
-	params.AddExtra("payment_method_options[wechat_pay][client]", "web")
+	// https://docs.stripe.com/payments/wallets
+	opts := s.config.CheckoutOptions.merge(ex.CheckoutOptions)
+	createdAt := ex.CreatedAt
+	if createdAt.IsZero() {
+		if opts.ExpiresIn > 0 && ex.IdempotencyKey == "" {
+			return nil, fmt.Errorf("stripe checkout expires in requires created at or idempotency key, out_trade_no: %s", req.OutTradeNo)
+		}
+		createdAt = time.Now()
+	}
+	opts.apply(params, createdAt)
+
+	// 接口重试时返回同一个 Checkout Session，避免重复创建。
+	// Stripe 拒绝参数不同的同键请求，expires_at 一并计入幂等键
+	if ex.IdempotencyKey != "" {
+		params.SetIdempotencyKey(ex.IdempotencyKey)
+	} else {
+		params.SetIdempotencyKey(s.idempotencyKey("checkout", req.OutTradeNo, req.TotalAmount, req.Currency, ex.UIMode, stripe.Int64Value(params.ExpiresAt)))
+	}
+
 	cs, err := s.client.V1CheckoutSessions.Create(ctx, params)
 	if err != nil {
 		return nil, err
 	}
 
+	resEx := StripeExtraForTradePreCreateRes{
+		SessionID:    cs.ID,
+		ExpiresAt:    cs.ExpiresAt,
+		ClientSecret: cs.ClientSecret,
+		ReturnURL:    cs.ReturnURL,
+	}
+	if cs.PaymentIntent != nil {
+		resEx.PaymentIntentID = cs.PaymentIntent.ID
+	}
 	return &TradePreCreateRes{
-		OutTradeNo: cs.ID,
+		OutTradeNo: req.OutTradeNo,
 		PayURL:     cs.URL,
+		Extra:      resEx,
 	}, nil
 
 }
 
+// paymentIntentPrecreate 直接创建 PaymentIntent，前端使用 client_secret 通过 Stripe Elements 完成支付，
+// 支付成功后由 payment_intent.succeeded 事件履约
+//
+//	docs:
+//	https://docs.stripe.com/payments/accept-a-payment?platform=web&ui=elements
+func (s *StripeClient) paymentIntentPrecreate(ctx context.Context, req *TradePreCreateReq, ex StripeExtraForTradePreCreateReq) (*TradePreCreateRes, error) {
+	if ex.Mode == STRIPE_MODE_SUBSCRIPTION {
+		return nil, fmt.Errorf("stripe ui mode %s does not support subscription", ex.UIMode)
+	}
+	params := &stripe.PaymentIntentCreateParams{
+		Amount:      stripe.Int64(req.TotalAmount),
+		Currency:    stripe.String(strings.ToLower(string(req.Currency))),
+		Description: stripe.String(req.ProductSubject),
+		Metadata: map[string]string{
+			_OUT_TRADE_NO: req.OutTradeNo,
+			_STRIPE_FLOW:  _STRIPE_FLOW_PI,
+		},
+	}
+	opts := s.config.CheckoutOptions.merge(ex.CheckoutOptions)
+	if methods := opts.paymentMethods(false); len(methods) > 0 {
+		params.PaymentMethodTypes = stripe.StringSlice(methods)
+		if slices.Contains(methods, "wechat_pay") {
+			params.AddExtra("payment_method_options[wechat_pay][client]", "web")
+		}
+	} else {
+		params.AutomaticPaymentMethods = &stripe.PaymentIntentCreateAutomaticPaymentMethodsParams{
+			Enabled: stripe.Bool(true),
+		}
+	}
+	if opts.CustomerEmail != "" {
+		params.ReceiptEmail = stripe.String(opts.CustomerEmail)
+	}
+	if opts.CaptureMethod != "" {
+		params.CaptureMethod = stripe.String(opts.CaptureMethod)
+	}
+
+	if ex.IdempotencyKey != "" {
+		params.SetIdempotencyKey(ex.IdempotencyKey)
+	} else {
+		params.SetIdempotencyKey(s.idempotencyKey("payment_intent", req.OutTradeNo, req.TotalAmount, req.Currency))
+	}
+
+	pi, err := s.client.V1PaymentIntents.Create(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return &TradePreCreateRes{
+		OutTradeNo: req.OutTradeNo,
+		Extra: StripeExtraForTradePreCreateRes{
+			PaymentIntentID: pi.ID,
+			ClientSecret:    pi.ClientSecret,
+			ReturnURL:       s.returnURL(),
+		},
+	}, nil
+}
+
+// returnURL 嵌入式 Checkout 及 Elements 模式的返回URL
+func (s *StripeClient) returnURL() string {
+	if s.config.ReturnURL != "" {
+		return s.config.ReturnURL
+	}
+	return s.config.SuccessURL
+}
+
+// TradeRefund 订单退款，refundAmount 为退款金额(订单货币最小单位)，为 0 时全额退款
+//
+// idempotencyKey 为空时根据 PaymentKey、OutTradeNo、refundAmount 生成，24 小时内相同金额的重复退款请求只会执行一次，
+// 需对同一订单多次退款相同金额时请指定不同的键
+func (s *StripeClient) TradeRefund(ctx context.Context, outTradeNo string, refundAmount int64, idempotencyKey ...string) (*StripeTradeRefundRes, error) {
+	pi, err := s.paymentIntentByOutTradeNo(ctx, outTradeNo)
+	if err != nil {
+		return nil, err
+	}
+	params := &stripe.RefundCreateParams{
+		PaymentIntent: stripe.String(pi.ID),
+		Metadata: map[string]string{
+			_OUT_TRADE_NO: outTradeNo,
+		},
+	}
+	if refundAmount > 0 {
+		params.Amount = stripe.Int64(refundAmount)
+	}
+	if len(idempotencyKey) > 0 && idempotencyKey[0] != "" {
+		params.SetIdempotencyKey(idempotencyKey[0])
+	} else {
+		params.SetIdempotencyKey(s.idempotencyKey("refund", outTradeNo, refundAmount))
+	}
+
+	re, err := s.client.V1Refunds.Create(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return &StripeTradeRefundRes{
+		RefundID:        re.ID,
+		PaymentIntentID: pi.ID,
+		Amount:          re.Amount,
+		Currency:        string(re.Currency),
+		Status:          string(re.Status),
+	}, nil
+}
+
+// Capture 预授权扣款，amount 为扣款金额(订单货币最小单位)，为 0 时按预授权金额全额扣款，未扣部分自动释放
+//
+// 仅适用于 CaptureMethod=manual 创建的订单，预授权一般 7 天内有效，扣款成功后触发 payment_intent.succeeded 事件履约
+func (s *StripeClient) Capture(ctx context.Context, outTradeNo string, amount int64) (*StripeAuthorizationRes, error) {
+	pi, err := s.paymentIntentByOutTradeNo(ctx, outTradeNo)
+	if err != nil {
+		return nil, err
+	}
+	params := &stripe.PaymentIntentCaptureParams{}
+	if amount > 0 {
+		params.AmountToCapture = stripe.Int64(amount)
+	}
+	params.SetIdempotencyKey(s.idempotencyKey("capture", outTradeNo, amount))
+
+	pi, err = s.client.V1PaymentIntents.Capture(ctx, pi.ID, params)
+	if err != nil {
+		return nil, err
+	}
+	return stripeAuthorizationRes(pi), nil
+}
+
+// CancelAuthorization 取消预授权，释放冻结金额
+func (s *StripeClient) CancelAuthorization(ctx context.Context, outTradeNo string) (*StripeAuthorizationRes, error) {
+	pi, err := s.paymentIntentByOutTradeNo(ctx, outTradeNo)
+	if err != nil {
+		return nil, err
+	}
+	params := &stripe.PaymentIntentCancelParams{}
+	params.SetIdempotencyKey(s.idempotencyKey("cancel", outTradeNo))
+
+	pi, err = s.client.V1PaymentIntents.Cancel(ctx, pi.ID, params)
+	if err != nil {
+		return nil, err
+	}
+	return stripeAuthorizationRes(pi), nil
+}
+
+func stripeAuthorizationRes(pi *stripe.PaymentIntent) *StripeAuthorizationRes {
+	return &StripeAuthorizationRes{
+		PaymentIntentID:  pi.ID,
+		AmountCapturable: pi.AmountCapturable,
+		AmountReceived:   pi.AmountReceived,
+		Currency:         string(pi.Currency),
+		Status:           string(pi.Status),
+	}
+}
+
+// paymentIntentByOutTradeNo 根据 metadata 中的订单号查询 PaymentIntent
+//
+// Stripe 搜索接口数据存在约 1 分钟的延迟，刚支付的订单可能查询不到
+func (s *StripeClient) paymentIntentByOutTradeNo(ctx context.Context, outTradeNo string) (*stripe.PaymentIntent, error) {
+	params := &stripe.PaymentIntentSearchParams{}
+	params.Query = fmt.Sprintf("metadata['%s']:'%s'", _OUT_TRADE_NO, strings.ReplaceAll(outTradeNo, "'", "\\'"))
+	params.Limit = stripe.Int64(1)
+	for pi, err := range s.client.V1PaymentIntents.Search(ctx, params) {
+		if err != nil {
+			return nil, err
+		}
+		return pi, nil
+	}
+	return nil, fmt.Errorf("stripe payment intent not found, out_trade_no: %s", outTradeNo)
+}
+
+// idempotencyKey 根据 PaymentKey 及业务参数生成幂等键
+func (s *StripeClient) idempotencyKey(parts ...any) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v", s.config.PaymentKey)
+	for _, p := range parts {
+		fmt.Fprintf(h, ":%v", p)
+	}
+	return "paykit-" + hex.EncodeToString(h.Sum(nil))
+}
+
+// subscriptionParams 订阅模式参数，订单号同时写入订阅的 metadata，续费、取消等事件据此关联内部订单
+//
+//	docs:
+//	https://docs.stripe.com/billing/subscriptions/build-subscriptions?payment-ui=stripe-hosted
+func (s *StripeClient) subscriptionParams(params *stripe.CheckoutSessionCreateParams, req *TradePreCreateReq, ex StripeExtraForTradePreCreateReq) {
+	params.Mode = stripe.String(string(stripe.CheckoutSessionModeSubscription))
+	params.SubscriptionData = &stripe.CheckoutSessionCreateSubscriptionDataParams{
+		Metadata: map[string]string{
+			_OUT_TRADE_NO: req.OutTradeNo,
+		},
+	}
+
+	lineItem := &stripe.CheckoutSessionCreateLineItemParams{
+		Quantity: stripe.Int64(1),
+	}
+	if ex.PriceID != "" {
+		lineItem.Price = stripe.String(ex.PriceID)
+	} else {
+		interval := ex.Interval
+		if interval == "" {
+			interval = string(stripe.PriceRecurringIntervalMonth)
+		}
+		recurring := &stripe.CheckoutSessionCreateLineItemPriceDataRecurringParams{
+			Interval: stripe.String(interval),
+		}
+		if ex.IntervalCount > 0 {
+			recurring.IntervalCount = stripe.Int64(ex.IntervalCount)
+		}
+		lineItem.PriceData = &stripe.CheckoutSessionCreateLineItemPriceDataParams{
+			Currency: stripe.String(strings.ToLower(string(req.Currency))),
+			ProductData: &stripe.CheckoutSessionCreateLineItemPriceDataProductDataParams{
+				Name: stripe.String(req.ProductSubject),
+			},
+			UnitAmount: stripe.Int64(req.TotalAmount),
+			Recurring:  recurring,
+		}
+	}
+	params.LineItems = []*stripe.CheckoutSessionCreateLineItemParams{lineItem}
+}
+
 // Notify 异步回调，异步通知
 //
 //	docs:
@@ -122,17 +591,337 @@ func (s *StripeClient) Notify(w http.ResponseWriter, req *http.Request) {
 
 	s.logger.Debug(ctx, "stripe event.Type: ", event.Type)
 
-	if event.Type == stripe.EventTypeCheckoutSessionCompleted {
-		var cs stripe.CheckoutSession
-		err := json.Unmarshal(event.Data.Raw, &cs)
+	switch event.Type {
+	case stripe.EventTypeCheckoutSessionCompleted,
+		stripe.EventTypeCheckoutSessionAsyncPaymentSucceeded,
+		stripe.EventTypeCheckoutSessionAsyncPaymentFailed,
+		stripe.EventTypeCheckoutSessionExpired:
+		err = s.handleCheckoutSession(ctx, event)
+	case stripe.EventTypePaymentIntentSucceeded,
+		stripe.EventTypePaymentIntentPaymentFailed,
+		stripe.EventTypePaymentIntentAmountCapturableUpdated,
+		stripe.EventTypePaymentIntentCanceled:
+		err = s.handlePaymentIntent(ctx, event)
+	case stripe.EventTypeInvoicePaid, stripe.EventTypeInvoicePaymentFailed:
+		err = s.handleInvoice(ctx, event)
+	case stripe.EventTypeCustomerSubscriptionDeleted:
+		err = s.handleSubscriptionDeleted(ctx, event)
+	case stripe.EventTypeChargeRefunded:
+		err = s.handleChargeRefunded(ctx, event)
+	case stripe.EventTypeRefundUpdated:
+		err = s.handleRefundUpdated(ctx, event)
+	case stripe.EventTypeChargeDisputeCreated, stripe.EventTypeChargeDisputeClosed:
+		err = s.handleDispute(ctx, event)
+	}
+	if err != nil {
+		s.logger.Errorf(ctx, "Error handling webhook event %s: %v\n", event.Type, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// SetEventHandler 设置异步通知事件处理函数，支付成功、支付失败、订单过期等事件会归一化后回调
+func (s *StripeClient) SetEventHandler(handler func(*NotifyEvent)) {
+	s.eventHandler = handler
+}
+
+// SetRefundDisputeHandler 设置退款、争议(拒付)事件处理函数，与 SetEventHandler 的支付事件分开处理
+func (s *StripeClient) SetRefundDisputeHandler(handler func(*NotifyEvent)) {
+	s.disputeHandler = handler
+}
+
+// handleCheckoutSession 处理 Checkout Session 相关事件
+//
+// 延迟到账的支付方式(如银行借记)在 checkout.session.completed 时 payment_status 为 unpaid，
+// 需等待 checkout.session.async_payment_succeeded 后再履约
+func (s *StripeClient) handleCheckoutSession(ctx context.Context, event stripe.Event) error {
+	var cs stripe.CheckoutSession
+	err := json.Unmarshal(event.Data.Raw, &cs)
+	if err != nil {
+		return err
+	}
+	outTradeNo := cs.Metadata[_OUT_TRADE_NO]
+	if outTradeNo == "" {
+		outTradeNo = cs.ClientReferenceID
+	}
+	s.logger.Debugf(ctx, "stripe checkout session: %s, out_trade_no: %s, payment_status: %s", cs.ID, outTradeNo, cs.PaymentStatus)
+
+	var eventType NotifyEventType
+	switch event.Type {
+	case stripe.EventTypeCheckoutSessionCompleted, stripe.EventTypeCheckoutSessionAsyncPaymentSucceeded:
+		// unpaid 为延迟到账的支付方式，等待 async_payment_succeeded；no_payment_required(如全额优惠)无需付款，直接履约
+		if cs.PaymentStatus == stripe.CheckoutSessionPaymentStatusUnpaid {
+			return nil
+		}
+		s.fulfillCheckout(outTradeNo)
+		eventType = NOTIFY_EVENT_PAID
+	case stripe.EventTypeCheckoutSessionAsyncPaymentFailed:
+		eventType = NOTIFY_EVENT_FAILED
+	case stripe.EventTypeCheckoutSessionExpired:
+		eventType = NOTIFY_EVENT_EXPIRED
+	}
+
+	ex := StripeExtraForNotifyEvent{
+		EventID:     event.ID,
+		EventType:   string(event.Type),
+		SessionID:   cs.ID,
+		AmountTotal: cs.AmountTotal,
+		Currency:    string(cs.Currency),
+	}
+	if cs.PaymentIntent != nil {
+		ex.PaymentIntentID = cs.PaymentIntent.ID
+	}
+	s.emitEvent(eventType, outTradeNo, ex.PaymentIntentID, ex)
+	return nil
+}
+
+// handlePaymentIntent 处理 Elements 模式创建的 PaymentIntent 及预授权 PaymentIntent 的事件
+//
+// Checkout Session 创建的自动扣款 PaymentIntent 已由 checkout.session.* 事件处理，此处忽略；
+// 预授权订单在 checkout.session.completed 时 payment_status 为 unpaid，扣款成功后由 payment_intent.succeeded 履约
+func (s *StripeClient) handlePaymentIntent(ctx context.Context, event stripe.Event) error {
+	var pi stripe.PaymentIntent
+	err := json.Unmarshal(event.Data.Raw, &pi)
+	if err != nil {
+		return err
+	}
+	if pi.Metadata[_STRIPE_FLOW] != _STRIPE_FLOW_PI && pi.CaptureMethod != stripe.PaymentIntentCaptureMethodManual {
+		return nil
+	}
+	outTradeNo := pi.Metadata[_OUT_TRADE_NO]
+	s.logger.Debugf(ctx, "stripe payment intent: %s, out_trade_no: %s, status: %s", pi.ID, outTradeNo, pi.Status)
+
+	var eventType NotifyEventType
+	switch event.Type {
+	case stripe.EventTypePaymentIntentSucceeded:
+		s.fulfillCheckout(outTradeNo)
+		eventType = NOTIFY_EVENT_PAID
+	case stripe.EventTypePaymentIntentPaymentFailed:
+		eventType = NOTIFY_EVENT_FAILED
+	case stripe.EventTypePaymentIntentAmountCapturableUpdated:
+		if pi.Status != stripe.PaymentIntentStatusRequiresCapture {
+			return nil
+		}
+		eventType = NOTIFY_EVENT_AUTHORIZED
+	case stripe.EventTypePaymentIntentCanceled:
+		eventType = NOTIFY_EVENT_CLOSED
+	}
+
+	s.emitEvent(eventType, outTradeNo, pi.ID, StripeExtraForNotifyEvent{
+		EventID:          event.ID,
+		EventType:        string(event.Type),
+		PaymentIntentID:  pi.ID,
+		AmountTotal:      pi.Amount,
+		AmountCapturable: pi.AmountCapturable,
+		Currency:         string(pi.Currency),
+	})
+	return nil
+}
+
+// handleInvoice 处理订阅账单事件
+//
+// 首期账单(billing_reason=subscription_create)已由 checkout.session.completed 履约，不再重复触发续费事件
+func (s *StripeClient) handleInvoice(ctx context.Context, event stripe.Event) error {
+	var in stripe.Invoice
+	err := json.Unmarshal(event.Data.Raw, &in)
+	if err != nil {
+		return err
+	}
+	if in.Parent == nil || in.Parent.SubscriptionDetails == nil {
+		// 非订阅账单
+		return nil
+	}
+	details := in.Parent.SubscriptionDetails
+	outTradeNo := details.Metadata[_OUT_TRADE_NO]
+	s.logger.Debugf(ctx, "stripe invoice: %s, out_trade_no: %s, billing_reason: %s", in.ID, outTradeNo, in.BillingReason)
+
+	var eventType NotifyEventType
+	switch event.Type {
+	case stripe.EventTypeInvoicePaid:
+		if in.BillingReason == stripe.InvoiceBillingReasonSubscriptionCreate {
+			return nil
+		}
+		eventType = NOTIFY_EVENT_RENEWED
+	case stripe.EventTypeInvoicePaymentFailed:
+		eventType = NOTIFY_EVENT_FAILED
+	}
+
+	ex := StripeExtraForSubscriptionEvent{
+		EventID:       event.ID,
+		EventType:     string(event.Type),
+		InvoiceID:     in.ID,
+		BillingReason: string(in.BillingReason),
+		AmountPaid:    in.AmountPaid,
+		Currency:      string(in.Currency),
+	}
+	if details.Subscription != nil {
+		ex.SubscriptionID = details.Subscription.ID
+	}
+	s.emitEvent(eventType, outTradeNo, ex.SubscriptionID, ex)
+	return nil
+}
+
+// handleSubscriptionDeleted 处理订阅取消事件
+func (s *StripeClient) handleSubscriptionDeleted(ctx context.Context, event stripe.Event) error {
+	var sub stripe.Subscription
+	err := json.Unmarshal(event.Data.Raw, &sub)
+	if err != nil {
+		return err
+	}
+	outTradeNo := sub.Metadata[_OUT_TRADE_NO]
+	s.logger.Debugf(ctx, "stripe subscription deleted: %s, out_trade_no: %s", sub.ID, outTradeNo)
+
+	s.emitEvent(NOTIFY_EVENT_CANCELLED, outTradeNo, sub.ID, StripeExtraForSubscriptionEvent{
+		EventID:        event.ID,
+		EventType:      string(event.Type),
+		SubscriptionID: sub.ID,
+		Currency:       string(sub.Currency),
+	})
+	return nil
+}
+
+// handleChargeRefunded 处理退款事件
+func (s *StripeClient) handleChargeRefunded(ctx context.Context, event stripe.Event) error {
+	if s.disputeHandler == nil {
+		return nil
+	}
+	var ch stripe.Charge
+	err := json.Unmarshal(event.Data.Raw, &ch)
+	if err != nil {
+		return err
+	}
+	outTradeNo, err := s.outTradeNoByPaymentIntent(ctx, ch.PaymentIntent, ch.Metadata)
+	if err != nil {
+		return err
+	}
+	ex := StripeExtraForRefundDisputeEvent{
+		EventID:   event.ID,
+		EventType: string(event.Type),
+		ChargeID:  ch.ID,
+		Amount:    ch.AmountRefunded,
+		Currency:  string(ch.Currency),
+		Status:    string(ch.Status),
+	}
+	if ch.PaymentIntent != nil {
+		ex.PaymentIntentID = ch.PaymentIntent.ID
+	}
+	s.emitDisputeEvent(NOTIFY_EVENT_REFUNDED, outTradeNo, ex)
+	return nil
+}
+
+// handleRefundUpdated 处理退款状态变更事件
+func (s *StripeClient) handleRefundUpdated(ctx context.Context, event stripe.Event) error {
+	if s.disputeHandler == nil {
+		return nil
+	}
+	var re stripe.Refund
+	err := json.Unmarshal(event.Data.Raw, &re)
+	if err != nil {
+		return err
+	}
+	outTradeNo, err := s.outTradeNoByPaymentIntent(ctx, re.PaymentIntent, re.Metadata)
+	if err != nil {
+		return err
+	}
+	ex := StripeExtraForRefundDisputeEvent{
+		EventID:   event.ID,
+		EventType: string(event.Type),
+		RefundID:  re.ID,
+		Amount:    re.Amount,
+		Currency:  string(re.Currency),
+		Reason:    string(re.Reason),
+		Status:    string(re.Status),
+	}
+	if re.PaymentIntent != nil {
+		ex.PaymentIntentID = re.PaymentIntent.ID
+	}
+	if re.Charge != nil {
+		ex.ChargeID = re.Charge.ID
+	}
+	s.emitDisputeEvent(NOTIFY_EVENT_REFUND_UPDATED, outTradeNo, ex)
+	return nil
+}
+
+// handleDispute 处理争议(拒付)事件
+func (s *StripeClient) handleDispute(ctx context.Context, event stripe.Event) error {
+	if s.disputeHandler == nil {
+		return nil
+	}
+	var dp stripe.Dispute
+	err := json.Unmarshal(event.Data.Raw, &dp)
+	if err != nil {
+		return err
+	}
+	outTradeNo, err := s.outTradeNoByPaymentIntent(ctx, dp.PaymentIntent, dp.Metadata)
+	if err != nil {
+		return err
+	}
+	eventType := NOTIFY_EVENT_DISPUTE_CREATED
+	if event.Type == stripe.EventTypeChargeDisputeClosed {
+		eventType = NOTIFY_EVENT_DISPUTE_CLOSED
+	}
+	ex := StripeExtraForRefundDisputeEvent{
+		EventID:   event.ID,
+		EventType: string(event.Type),
+		DisputeID: dp.ID,
+		Amount:    dp.Amount,
+		Currency:  string(dp.Currency),
+		Reason:    string(dp.Reason),
+		Status:    string(dp.Status),
+	}
+	if dp.PaymentIntent != nil {
+		ex.PaymentIntentID = dp.PaymentIntent.ID
+	}
+	if dp.Charge != nil {
+		ex.ChargeID = dp.Charge.ID
+	}
+	s.emitDisputeEvent(eventType, outTradeNo, ex)
+	return nil
+}
+
+// outTradeNoByPaymentIntent 获取订单号：优先从事件对象的 metadata 获取，否则查询 PaymentIntent 的 metadata
+func (s *StripeClient) outTradeNoByPaymentIntent(ctx context.Context, pi *stripe.PaymentIntent, metadata map[string]string) (string, error) {
+	if no := metadata[_OUT_TRADE_NO]; no != "" {
+		return no, nil
+	}
+	if pi == nil || pi.ID == "" {
+		return "", nil
+	}
+	if pi.Metadata == nil {
+		// webhook 事件中 payment_intent 未展开，仅包含 ID
+		var err error
+		pi, err = s.client.V1PaymentIntents.Retrieve(ctx, pi.ID, nil)
 		if err != nil {
-			s.logger.Errorf(ctx, "Error parsing webhook JSON: %v\n", err.Error())
-			w.WriteHeader(http.StatusBadRequest)
-			return
+			return "", err
 		}
-		s.fulfillCheckout(cs.Metadata[_OUT_TRADE_NO])
 	}
-	w.WriteHeader(http.StatusOK)
+	return pi.Metadata[_OUT_TRADE_NO], nil
+}
+
+func (s *StripeClient) emitDisputeEvent(eventType NotifyEventType, outTradeNo string, ex StripeExtraForRefundDisputeEvent) {
+	s.disputeHandler(&NotifyEvent{
+		PaymentKey:  s.config.PaymentKey,
+		PaymentType: PAYMENT_TYPE_STRIPE,
+		Type:        eventType,
+		OutTradeNo:  outTradeNo,
+		TradeNo:     ex.PaymentIntentID,
+		Extra:       ex,
+	})
+}
+
+func (s *StripeClient) emitEvent(eventType NotifyEventType, outTradeNo, tradeNo string, extra any) {
+	if s.eventHandler == nil {
+		return
+	}
+	s.eventHandler(&NotifyEvent{
+		PaymentKey:  s.config.PaymentKey,
+		PaymentType: PAYMENT_TYPE_STRIPE,
+		Type:        eventType,
+		OutTradeNo:  outTradeNo,
+		TradeNo:     tradeNo,
+		Extra:       extra,
+	})
 }
 func (s *StripeClient) Start() error {
 	return nil
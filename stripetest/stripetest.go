@@ -0,0 +1,186 @@
+// Package stripetest 构造带签名的 Stripe webhook 测试事件，配合 httptest 离线测试 paykit.StripeClient.Notify
+package stripetest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v82"
+	"github.com/stripe/stripe-go/v82/webhook"
+)
+
+// _OUT_TRADE_NO 与 paykit 写入 metadata 的订单号键一致
+const _OUT_TRADE_NO = "OUT_TRADE_NO"
+
+// Order 测试事件中的订单信息
+type Order struct {
+	OutTradeNo string
+	Amount     int64  // 订单金额，货币最小单位
+	Currency   string // 货币(小写)，默认为 usd
+}
+
+// Signature 使用 endpoint secret 对 payload 签名，返回 Stripe-Signature 请求头
+func Signature(payload []byte, secret string) string {
+	return webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{
+		Payload:   payload,
+		Secret:    secret,
+		Timestamp: time.Now(),
+	}).Header
+}
+
+// WebhookRequest 构造带签名的 webhook 请求，可直接传给 StripeClient.Notify
+func WebhookRequest(payload []byte, secret string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Stripe-Signature", Signature(payload, secret))
+	return req
+}
+
+// CheckoutSessionCompleted checkout.session.completed 事件，paid 为 false 时模拟延迟到账的支付方式
+func CheckoutSessionCompleted(o Order, paid bool) []byte {
+	status := stripe.CheckoutSessionPaymentStatusUnpaid
+	if paid {
+		status = stripe.CheckoutSessionPaymentStatusPaid
+	}
+	return event(stripe.EventTypeCheckoutSessionCompleted, o.checkoutSession(stripe.CheckoutSessionStatusComplete, status))
+}
+
+// CheckoutSessionNoPaymentRequired payment_status 为 no_payment_required 的 checkout.session.completed 事件，
+// 模拟优惠码抵扣全部金额等无需付款的订单
+func CheckoutSessionNoPaymentRequired(o Order) []byte {
+	session := o.checkoutSession(stripe.CheckoutSessionStatusComplete, stripe.CheckoutSessionPaymentStatusNoPaymentRequired)
+	session["amount_total"] = 0
+	session["payment_intent"] = nil
+	return event(stripe.EventTypeCheckoutSessionCompleted, session)
+}
+
+// CheckoutSessionAsyncPaymentSucceeded checkout.session.async_payment_succeeded 事件
+func CheckoutSessionAsyncPaymentSucceeded(o Order) []byte {
+	return event(stripe.EventTypeCheckoutSessionAsyncPaymentSucceeded, o.checkoutSession(stripe.CheckoutSessionStatusComplete, stripe.CheckoutSessionPaymentStatusPaid))
+}
+
+// CheckoutSessionExpired checkout.session.expired 事件
+func CheckoutSessionExpired(o Order) []byte {
+	session := o.checkoutSession(stripe.CheckoutSessionStatusExpired, stripe.CheckoutSessionPaymentStatusUnpaid)
+	session["payment_intent"] = nil
+	return event(stripe.EventTypeCheckoutSessionExpired, session)
+}
+
+// ChargeRefunded charge.refunded 事件，amountRefunded 为累计退款金额，为 0 时全额退款
+func ChargeRefunded(o Order, amountRefunded int64) []byte {
+	if amountRefunded <= 0 {
+		amountRefunded = o.Amount
+	}
+	return event(stripe.EventTypeChargeRefunded, map[string]any{
+		"id":              o.id("ch"),
+		"object":          "charge",
+		"amount":          o.Amount,
+		"amount_captured": o.Amount,
+		"amount_refunded": amountRefunded,
+		"captured":        true,
+		"currency":        o.currency(),
+		"livemode":        false,
+		"metadata":        o.metadata(),
+		"paid":            true,
+		"payment_intent":  o.id("pi"),
+		"refunded":        amountRefunded >= o.Amount,
+		"status":          stripe.ChargeStatusSucceeded,
+	})
+}
+
+// ChargeDisputeCreated charge.dispute.created 事件
+//
+// 与真实事件一致，争议对象 metadata 为空，StripeClient 查询 PaymentIntent 获取订单号，
+// 测试时需将 GET /v1/payment_intents/{id} 指向返回 PaymentIntent(o) 的模拟服务
+func ChargeDisputeCreated(o Order) []byte {
+	return event(stripe.EventTypeChargeDisputeCreated, map[string]any{
+		"id":             o.id("dp"),
+		"object":         "dispute",
+		"amount":         o.Amount,
+		"charge":         o.id("ch"),
+		"currency":       o.currency(),
+		"livemode":       false,
+		"metadata":       map[string]string{},
+		"payment_intent": o.id("pi"),
+		"reason":         stripe.DisputeReasonFraudulent,
+		"status":         stripe.DisputeStatusNeedsResponse,
+	})
+}
+
+// PaymentIntent 订单对应的 PaymentIntent 对象，用于模拟 GET /v1/payment_intents/{id} 的响应
+func PaymentIntent(o Order) []byte {
+	b, _ := json.Marshal(map[string]any{
+		"id":              o.id("pi"),
+		"object":          "payment_intent",
+		"amount":          o.Amount,
+		"amount_received": o.Amount,
+		"currency":        o.currency(),
+		"livemode":        false,
+		"metadata":        o.metadata(),
+		"status":          stripe.PaymentIntentStatusSucceeded,
+	})
+	return b
+}
+
+// PaymentIntentID 订单对应的 PaymentIntent ID
+func PaymentIntentID(o Order) string {
+	return o.id("pi")
+}
+
+func (o Order) checkoutSession(status stripe.CheckoutSessionStatus, paymentStatus stripe.CheckoutSessionPaymentStatus) map[string]any {
+	return map[string]any{
+		"id":                  o.id("cs"),
+		"object":              "checkout.session",
+		"amount_subtotal":     o.Amount,
+		"amount_total":        o.Amount,
+		"client_reference_id": o.OutTradeNo,
+		"currency":            o.currency(),
+		"expires_at":          time.Now().Add(24 * time.Hour).Unix(),
+		"livemode":            false,
+		"metadata":            o.metadata(),
+		"mode":                stripe.CheckoutSessionModePayment,
+		"payment_intent":      o.id("pi"),
+		"payment_status":      paymentStatus,
+		"status":              status,
+		"ui_mode":             stripe.CheckoutSessionUIModeHosted,
+	}
+}
+
+func (o Order) currency() string {
+	if o.Currency == "" {
+		return string(stripe.CurrencyUSD)
+	}
+	return strings.ToLower(o.Currency)
+}
+
+func (o Order) metadata() map[string]string {
+	return map[string]string{_OUT_TRADE_NO: o.OutTradeNo}
+}
+
+// id 根据订单号生成固定的对象 ID，同一订单的 Checkout Session、PaymentIntent、Charge 相互对应
+func (o Order) id(prefix string) string {
+	return prefix + "_test_" + hex.EncodeToString([]byte(o.OutTradeNo))
+}
+
+func event(eventType stripe.EventType, object map[string]any) []byte {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	payload, _ := json.Marshal(map[string]any{
+		"id":               "evt_test_" + hex.EncodeToString(b),
+		"object":           "event",
+		"api_version":      stripe.APIVersion,
+		"created":          time.Now().Unix(),
+		"livemode":         false,
+		"pending_webhooks": 1,
+		"request":          map[string]any{"id": nil, "idempotency_key": nil},
+		"type":             eventType,
+		"data":             map[string]any{"object": object},
+	})
+	return payload
+}
@@ -1,9 +1,21 @@
 package test
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,6 +33,11 @@ func TestTradePrecreate(t *testing.T) {
 			OutTradeNo:     fmt.Sprintf("test_order_%d", time.Now().Unix()),
 			TotalAmount:    101, // 101 分 == 1.01 元
 			Currency:       "CNY",
+			Extra: paykit.AlipayExtraForTradePreCreateReq{
+				TimeoutExpress: "15m",
+				Body:           "Test Body",
+				PassbackParams: "user_id=1&coupon=a b",
+			},
 		}
 		config = paykit.AlipayConfig{
 			PaymentKey:       "Alipay 1",
@@ -41,6 +58,9 @@ func TestTradePrecreate(t *testing.T) {
 		t.Fatalf("Failed to create Alipay client: %v", err)
 	}
 	client.SetDebug(true)
+	client.SetEventHandler(func(e *paykit.NotifyEvent) {
+		fmt.Printf("Notify event: %s, %s, %+v\n", e.Type, e.OutTradeNo, e.Extra)
+	})
 
 	res, err := client.TradePrecreate(ctx, req)
 	if err != nil {
@@ -60,3 +80,179 @@ func TestTradePrecreate(t *testing.T) {
 	}
 
 }
+
+// clear && go test ./test -v -run TestTradePay
+func TestTradePay(t *testing.T) {
+	var (
+		ctx = gctx.New()
+
+		req = &paykit.TradePreCreateReq{
+			ProductSubject: "Test Product",
+			OutTradeNo:     fmt.Sprintf("test_order_%d", time.Now().Unix()),
+			TotalAmount:    101, // 101 分 == 1.01 元
+			Currency:       "CNY",
+		}
+		config = paykit.AlipayConfig{
+			PaymentKey:       "Alipay 1",
+			AppId:            os.Getenv("AlipaySandbox_AppId"),
+			AppPrivateKey:    os.Getenv("AlipaySandbox_AppPrivateKey"),
+			AlipayPublicCert: os.Getenv("AlipaySandbox_AlipayPublicCert"),
+			IsProd:           false,
+			SuccessURL:       os.Getenv("NotifyURL"),
+			TradePayTimeout:  30,
+		}
+	)
+
+	client, err := paykit.NewAlipayClient(config, func(s string) {
+		fmt.Printf("Order completed: %s\n", s)
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Alipay client: %v", err)
+	}
+	client.SetDebug(true)
+
+	// 沙箱版支付宝 App 中的付款码
+	res, err := client.TradePay(ctx, os.Getenv("AlipaySandbox_AuthCode"), req)
+	if err != nil {
+		t.Fatalf("TradePay call failed: %v", err)
+	}
+	fmt.Printf("Trade pay result:\n")
+	fmt.Printf("Order No: %s\n", res.OutTradeNo)
+	fmt.Printf("Extra: %+v\n", res.Extra)
+}
+
+// alipayFailingTransport 记录请求的接口名并返回网络错误
+type alipayFailingTransport struct {
+	methods []string
+}
+
+func (f *alipayFailingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		body, _ := io.ReadAll(r.Body)
+		r.URL.RawQuery = strings.Trim(r.URL.RawQuery+"&"+string(body), "&")
+	}
+	f.methods = append(f.methods, r.URL.Query().Get("method"))
+	return nil, errors.New("connection reset by peer")
+}
+
+// clear && go test ./test -v -run TestTradePayTransportError
+func TestTradePayTransportError(t *testing.T) {
+	ctx := gctx.New()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey error: %v", err)
+	}
+	transport := &alipayFailingTransport{}
+	client, err := paykit.NewAlipayClient(paykit.AlipayConfig{
+		PaymentKey:    "Alipay 1",
+		AppId:         "2021000000000000",
+		AppPrivateKey: base64.StdEncoding.EncodeToString(der),
+		HTTPClient:    &http.Client{Transport: transport},
+	}, func(s string) {
+		t.Errorf("unexpected fulfillment: %s", s)
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Alipay client: %v", err)
+	}
+
+	// 网络异常时支付结果未知，撤销交易
+	_, err = client.TradePay(ctx, "287654321012345678", &paykit.TradePreCreateReq{
+		ProductSubject: "Test Product",
+		OutTradeNo:     "test_order_transport",
+		TotalAmount:    101,
+		Currency:       paykit.CurrencyCNY,
+	})
+	if err == nil {
+		t.Fatal("expected transport error")
+	}
+	if !slices.Equal(transport.methods, []string{"alipay.trade.pay", "alipay.trade.cancel"}) {
+		t.Errorf("expected the trade to be cancelled after a transport error, requests: %v", transport.methods)
+	}
+}
+
+// clear && go test ./test -v -run TestAlipayNotifyTradeStatus
+func TestAlipayNotifyTradeStatus(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey error: %v", err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey error: %v", err)
+	}
+	var (
+		fulfilled []string
+		events    []paykit.NotifyEventType
+	)
+	// 测试密钥同时作为应用私钥及支付宝公钥，用于签名异步通知
+	client, err := paykit.NewAlipayClient(paykit.AlipayConfig{
+		PaymentKey:       "Alipay 1",
+		AppId:            "2021000000000000",
+		AppPrivateKey:    base64.StdEncoding.EncodeToString(der),
+		AlipayPublicCert: base64.StdEncoding.EncodeToString(pub),
+	}, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Alipay client: %v", err)
+	}
+	client.SetEventHandler(func(e *paykit.NotifyEvent) {
+		events = append(events, e.Type)
+	})
+
+	notify := func(outTradeNo, status string) {
+		values := url.Values{
+			"app_id":       {"2021000000000000"},
+			"notify_id":    {"notify_" + outTradeNo},
+			"out_trade_no": {outTradeNo},
+			"trade_no":     {"trade_" + outTradeNo},
+			"trade_status": {status},
+			"total_amount": {"1.01"},
+			"sign_type":    {"RSA2"},
+		}
+		// 签名内容为除 sign、sign_type 外的参数按键名排序拼接
+		var pairs []string
+		for k, v := range values {
+			if k != "sign_type" {
+				pairs = append(pairs, k+"="+v[0])
+			}
+		}
+		slices.Sort(pairs)
+		digest := sha256.Sum256([]byte(strings.Join(pairs, "&")))
+		sign, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("SignPKCS1v15 error: %v", err)
+		}
+		values.Set("sign", base64.StdEncoding.EncodeToString(sign))
+
+		req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		client.Notify(w, req)
+		if w.Body.String() != "success" {
+			t.Fatalf("unexpected ack of %s: %q", status, w.Body.String())
+		}
+	}
+
+	// 交易关闭及等待付款的通知不履约
+	notify("test_order_closed", "TRADE_CLOSED")
+	notify("test_order_waiting", "WAIT_BUYER_PAY")
+	if len(fulfilled) != 0 {
+		t.Fatalf("unexpected fulfillments: %v", fulfilled)
+	}
+	notify("test_order_success", "TRADE_SUCCESS")
+	if !slices.Equal(fulfilled, []string{"test_order_success"}) {
+		t.Errorf("unexpected fulfillments: %v", fulfilled)
+	}
+	if !slices.Equal(events, []paykit.NotifyEventType{paykit.NOTIFY_EVENT_CLOSED, paykit.NOTIFY_EVENT_PAID}) {
+		t.Errorf("unexpected events: %v", events)
+	}
+}
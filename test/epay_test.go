@@ -1,12 +1,26 @@
 package test
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gogf/gf/v2/crypto/gmd5"
+	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/ppoonk/paykit"
 )
 
@@ -58,3 +72,391 @@ func TestEpayTradePrecreate(t *testing.T) {
 		t.Fatalf("Failed to start HTTP server: %v", err)
 	}
 }
+
+// clear && go test ./test -v -run TestEpayNotify
+func TestEpayNotify(t *testing.T) {
+	var (
+		ctx    = gctx.New()
+		config = paykit.EpayConfig{
+			PaymentKey: "Epay 1",
+			Url:        "https://epay.example.com/submit.php",
+			Pid:        "1001",
+			Key:        "test_key",
+			NotifyURL:  "https://example.com/notify",
+			ReturnURL:  "https://example.com/return",
+		}
+		fulfilled []string
+	)
+
+	client, err := paykit.NewEpayClient(config, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Epay client: %v", err)
+	}
+	_, err = client.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+		ProductSubject: "Test Product",
+		OutTradeNo:     "test_order_1",
+		TotalAmount:    1000,
+		Currency:       paykit.CurrencyCNY,
+	})
+	if err != nil {
+		t.Fatalf("TradePrecreate call failed: %v", err)
+	}
+
+	// 按 epay 规则构造签名后的异步通知参数
+	notifyQuery := func(pid, money, key string) string {
+		q := url.Values{
+			"pid":          {pid},
+			"trade_no":     {"2025010112345678"},
+			"out_trade_no": {"test_order_1"},
+			"type":         {"alipay"},
+			"name":         {"Test Product"},
+			"money":        {money},
+			"trade_status": {"TRADE_SUCCESS"},
+		}
+		q.Set("sign", epayTestSign(q, key))
+		q.Set("sign_type", "MD5")
+		return q.Encode()
+	}
+
+	cases := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"bad sign", notifyQuery("1001", "10.00", "wrong_key"), http.StatusBadRequest},
+		{"bad pid", notifyQuery("1002", "10.00", "test_key"), http.StatusBadRequest},
+		{"bad money", notifyQuery("1001", "0.01", "test_key"), http.StatusBadRequest},
+		{"success", notifyQuery("1001", "10.00", "test_key"), http.StatusOK},
+		{"replay", notifyQuery("1001", "10.00", "test_key"), http.StatusOK},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		client.Notify(w, httptest.NewRequest(http.MethodGet, "/notify?"+c.query, nil))
+		if w.Code != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.status, w.Code)
+		}
+	}
+	if len(fulfilled) != 1 || fulfilled[0] != "test_order_1" {
+		t.Errorf("expected test_order_1 fulfilled once, got %v", fulfilled)
+	}
+
+	// 进程重启后订单缓存丢失，签名正确的通知仍然履约
+	restarted, err := paykit.NewEpayClient(config, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Epay client: %v", err)
+	}
+	w := httptest.NewRecorder()
+	restarted.Notify(w, httptest.NewRequest(http.MethodGet, "/notify?"+notifyQuery("1001", "10.00", "test_key"), nil))
+	if w.Code != http.StatusOK || w.Body.String() != "success" || len(fulfilled) != 2 {
+		t.Errorf("expected notify accepted after restart, got %d %q, fulfilled: %v", w.Code, w.Body.String(), fulfilled)
+	}
+}
+
+// clear && go test ./test -v -run TestEpayPayURL
+func TestEpayPayURL(t *testing.T) {
+	var (
+		ctx    = gctx.New()
+		config = paykit.EpayConfig{
+			PaymentKey: "Epay 1",
+			Url:        "https://epay.example.com/submit.php",
+			Pid:        "1001",
+			Key:        "test_key",
+			NotifyURL:  "https://example.com/notify?from=epay&id=1",
+			ReturnURL:  "https://example.com/return",
+		}
+	)
+	client, err := paykit.NewEpayClient(config, func(s string) {})
+	if err != nil {
+		t.Fatalf("Failed to create Epay client: %v", err)
+	}
+	res, err := client.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+		ProductSubject: "会员 & VIP 月卡",
+		OutTradeNo:     "test_order_2",
+		TotalAmount:    1990,
+		Currency:       paykit.CurrencyCNY,
+		Extra: paykit.EpayExtraForTradePreCreateReq{
+			Type:     paykit.EPAY_PAY_TYPE_WXPAY,
+			SiteName: "Test Site",
+		},
+	})
+	if err != nil {
+		t.Fatalf("TradePrecreate call failed: %v", err)
+	}
+
+	u, err := url.Parse(res.PayURL)
+	if err != nil {
+		t.Fatalf("invalid pay url: %v", err)
+	}
+	q := u.Query()
+	if q.Get("name") != "会员 & VIP 月卡" || q.Get("notify_url") != config.NotifyURL {
+		t.Errorf("params not encoded correctly: %s", res.PayURL)
+	}
+	if q.Get("type") != "wxpay" || q.Get("sitename") != "Test Site" || q.Has("param") {
+		t.Errorf("unexpected extra params: %s", res.PayURL)
+	}
+	if q.Get("money") != "19.90" {
+		t.Errorf("expected money 19.90, got %s", q.Get("money"))
+	}
+	if q.Get("sign") != epayTestSign(q, config.Key) {
+		t.Errorf("invalid sign: %s", res.PayURL)
+	}
+}
+
+// epayTestSign 按 epay 规则计算签名
+func epayTestSign(q url.Values, key string) string {
+	return gmd5.MustEncryptString(epayTestSignContent(q) + key)
+}
+
+// epayTestSignContent 按 epay 规则拼接待签名字符串
+func epayTestSignContent(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		if k == "sign" || k == "sign_type" || q.Get(k) == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, k+"="+q.Get(k))
+	}
+	return strings.Join(parts, "&")
+}
+
+// clear && go test ./test -v -run TestEpayMapi
+func TestEpayMapi(t *testing.T) {
+	ctx := gctx.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.URL.Path != "/mapi.php" || r.Form.Get("sign") != epayTestSign(r.PostForm, "test_key") {
+			w.Write([]byte(`{"code":-1,"msg":"签名错误"}`))
+			return
+		}
+		w.Write([]byte(`{"code":1,"msg":"","trade_no":"2025010112345678","qrcode":"weixin://wxpay/bizpayurl?pr=abc"}`))
+	}))
+	defer server.Close()
+
+	config := paykit.EpayConfig{
+		PaymentKey: "Epay 1",
+		Url:        server.URL + "/submit.php",
+		Pid:        "1001",
+		Key:        "test_key",
+		NotifyURL:  "https://example.com/notify",
+		Mode:       paykit.EPAY_MODE_API,
+	}
+	client, err := paykit.NewEpayClient(config, func(s string) {})
+	if err != nil {
+		t.Fatalf("Failed to create Epay client: %v", err)
+	}
+	req := &paykit.TradePreCreateReq{
+		ProductSubject: "Test Product",
+		OutTradeNo:     "test_order_3",
+		TotalAmount:    100,
+		Currency:       paykit.CurrencyCNY,
+		Extra: paykit.EpayExtraForTradePreCreateReq{
+			Type:     paykit.EPAY_PAY_TYPE_WXPAY,
+			ClientIP: "127.0.0.1",
+		},
+	}
+	res, err := client.TradePrecreate(ctx, req)
+	if err != nil {
+		t.Fatalf("TradePrecreate call failed: %v", err)
+	}
+	ex := res.Extra.(paykit.EpayExtraForTradePreCreateRes)
+	if res.PayURL != "weixin://wxpay/bizpayurl?pr=abc" || ex.TradeNo != "2025010112345678" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	// 签名错误时返回错误
+	client, _ = paykit.NewEpayClient(paykit.EpayConfig{Url: config.Url, Key: "wrong_key", Mode: paykit.EPAY_MODE_API}, func(s string) {})
+	if _, err = client.TradePrecreate(ctx, req); err == nil {
+		t.Errorf("expected error for bad sign")
+	}
+}
+
+// clear && go test ./test -v -run TestEpayV2
+func TestEpayV2(t *testing.T) {
+	ctx := gctx.New()
+	merchantKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	platformKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	platformPub, _ := x509.MarshalPKIXPublicKey(&platformKey.PublicKey)
+	merchantPriv, _ := x509.MarshalPKCS8PrivateKey(merchantKey)
+
+	rsaSign := func(q url.Values) string {
+		hashed := sha256.Sum256([]byte(epayTestSignContent(q)))
+		sign, _ := rsa.SignPKCS1v15(rand.Reader, platformKey, crypto.SHA256, hashed[:])
+		return base64.StdEncoding.EncodeToString(sign)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		sign, _ := base64.StdEncoding.DecodeString(r.PostForm.Get("sign"))
+		hashed := sha256.Sum256([]byte(epayTestSignContent(r.PostForm)))
+		if rsa.VerifyPKCS1v15(&merchantKey.PublicKey, crypto.SHA256, hashed[:], sign) != nil {
+			w.Write([]byte(`{"code":-1,"msg":"签名错误"}`))
+			return
+		}
+		var res url.Values
+		switch r.URL.Path {
+		case "/api/pay/create":
+			res = url.Values{"code": {"0"}, "trade_no": {"2025010112345678"}, "pay_type": {"qrcode"}, "pay_info": {"https://qr.alipay.com/abc"}, "timestamp": {"1735689600"}}
+		case "/api/pay/query":
+			res = url.Values{"code": {"0"}, "trade_no": {"2025010112345678"}, "out_trade_no": {r.PostForm.Get("out_trade_no")}, "status": {"1"}, "money": {"1.00"}, "timestamp": {"1735689600"}}
+		}
+		body := map[string]any{"sign": rsaSign(res), "sign_type": "RSA"}
+		for k := range res {
+			body[k] = res.Get(k)
+		}
+		body["code"] = 0
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	defer server.Close()
+
+	var fulfilled []string
+	client, err := paykit.NewEpayClient(paykit.EpayConfig{
+		PaymentKey:        "Epay V2",
+		Url:               server.URL + "/",
+		Pid:               "1001",
+		NotifyURL:         "https://example.com/notify",
+		Version:           paykit.EPAY_VERSION_V2,
+		PrivateKey:        base64.StdEncoding.EncodeToString(merchantPriv),
+		PlatformPublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: platformPub})),
+	}, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Epay client: %v", err)
+	}
+
+	res, err := client.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+		ProductSubject: "Test Product",
+		OutTradeNo:     "test_order_v2",
+		TotalAmount:    100,
+		Currency:       paykit.CurrencyCNY,
+		Extra:          paykit.EpayExtraForTradePreCreateReq{Type: paykit.EPAY_PAY_TYPE_ALIPAY, ClientIP: "127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatalf("TradePrecreate call failed: %v", err)
+	}
+	if res.PayURL != "https://qr.alipay.com/abc" {
+		t.Errorf("unexpected pay url: %s", res.PayURL)
+	}
+
+	queryRes, err := client.TradeQuery(ctx, "test_order_v2")
+	if err != nil {
+		t.Fatalf("TradeQuery call failed: %v", err)
+	}
+	if queryRes.Status != paykit.TRADE_STATUS_PAID || queryRes.Money != "1.00" {
+		t.Errorf("unexpected query result: %+v", queryRes)
+	}
+
+	notify := url.Values{
+		"pid":          {"1001"},
+		"trade_no":     {"2025010112345678"},
+		"out_trade_no": {"test_order_v2"},
+		"type":         {"alipay"},
+		"money":        {"1.00"},
+		"trade_status": {"TRADE_SUCCESS"},
+		"timestamp":    {"1735689600"},
+	}
+	notify.Set("sign", rsaSign(notify))
+	notify.Set("sign_type", "RSA")
+	tampered := url.Values{}
+	for k, v := range notify {
+		tampered[k] = v
+	}
+	tampered.Set("out_trade_no", "other_order")
+
+	for _, c := range []struct {
+		query  url.Values
+		status int
+	}{
+		{tampered, http.StatusBadRequest},
+		{notify, http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		client.Notify(w, httptest.NewRequest(http.MethodGet, "/notify?"+c.query.Encode(), nil))
+		if w.Code != c.status {
+			t.Errorf("expected status %d, got %d", c.status, w.Code)
+		}
+	}
+	if len(fulfilled) != 1 || fulfilled[0] != "test_order_v2" {
+		t.Errorf("expected test_order_v2 fulfilled once, got %v", fulfilled)
+	}
+}
+
+// clear && go test ./test -v -run TestEpayQueryRefund
+func TestEpayQueryRefund(t *testing.T) {
+	ctx := gctx.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.URL.Path != "/api.php" || r.Form.Get("pid") != "1001" || r.Form.Get("key") != "test_key" {
+			w.Write([]byte(`{"code":-1,"msg":"商户信息错误"}`))
+			return
+		}
+		switch r.Form.Get("act") {
+		case "order":
+			w.Write([]byte(`{"code":1,"msg":"查询订单号成功！","trade_no":"2025010112345678","out_trade_no":"` +
+				r.Form.Get("out_trade_no") + `","type":"alipay","status":1,"money":"5.00"}`))
+		case "refund":
+			w.Write([]byte(`{"code":1,"msg":"退款成功"}`))
+		}
+	}))
+	defer server.Close()
+
+	var fulfilled []string
+	client, err := paykit.NewEpayClient(paykit.EpayConfig{
+		PaymentKey: "Epay 1",
+		Url:        server.URL + "/submit.php",
+		Pid:        "1001",
+		Key:        "test_key",
+	}, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Epay client: %v", err)
+	}
+	_, err = client.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+		ProductSubject: "Test Product",
+		OutTradeNo:     "test_order_4",
+		TotalAmount:    500,
+		Currency:       paykit.CurrencyCNY,
+	})
+	if err != nil {
+		t.Fatalf("TradePrecreate call failed: %v", err)
+	}
+
+	queryRes, err := client.TradeQuery(ctx, "test_order_4")
+	if err != nil {
+		t.Fatalf("TradeQuery call failed: %v", err)
+	}
+	if queryRes.Status != paykit.TRADE_STATUS_PAID || queryRes.Type != paykit.EPAY_PAY_TYPE_ALIPAY {
+		t.Errorf("unexpected query result: %+v", queryRes)
+	}
+
+	// 轮询两次，只履约一次
+	for range 2 {
+		res, err := client.TradeSync(ctx, "test_order_4")
+		if err != nil {
+			t.Fatalf("TradeSync call failed: %v", err)
+		}
+		if res.Status != paykit.TRADE_STATUS_PAID {
+			t.Errorf("expected paid status, got %+v", res)
+		}
+	}
+	if len(fulfilled) != 1 || fulfilled[0] != "test_order_4" {
+		t.Errorf("expected test_order_4 fulfilled once, got %v", fulfilled)
+	}
+
+	refundRes, err := client.TradeRefund(ctx, "test_order_4", 200)
+	if err != nil {
+		t.Fatalf("TradeRefund call failed: %v", err)
+	}
+	if refundRes.RefundMoney != "2.00" {
+		t.Errorf("unexpected refund result: %+v", refundRes)
+	}
+}
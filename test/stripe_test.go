@@ -1,13 +1,20 @@
 package test
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/ppoonk/paykit"
+	"github.com/ppoonk/paykit/stripetest"
+	"github.com/stripe/stripe-go/v82"
 )
 
 // clear && go test -v test/stripe_test.go
@@ -39,7 +46,7 @@ func TestStripe(t *testing.T) {
 		t.Error("c.TradePrecreate error", err.Error())
 		return
 	}
-	t.Logf("test create stripe checkout sessions success, url: %v", cs.PayURL)
+	t.Logf("test create stripe checkout sessions success, out_trade_no: %v, url: %v, extra: %+v", cs.OutTradeNo, cs.PayURL, cs.Extra)
 
 	// 测试 webhook
 	http.HandleFunc("/webhook", c.Notify)
@@ -47,3 +54,632 @@ func TestStripe(t *testing.T) {
 	log.Printf("Listening on %s", addr)
 	log.Fatal(http.ListenAndServe(addr, nil))
 }
+
+// clear && go test ./test -v -run TestStripeNotifyCheckoutSession
+func TestStripeNotifyCheckoutSession(t *testing.T) {
+	const secret = "whsec_test"
+	var (
+		fulfilled []string
+		events    []paykit.NotifyEventType
+	)
+	c, err := paykit.NewStripeClient(paykit.StripeConfig{
+		PaymentKey:     "Stripe 1",
+		EndpointSecret: secret,
+	}, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("NewStripeClient error: %v", err)
+	}
+	c.SetEventHandler(func(e *paykit.NotifyEvent) {
+		events = append(events, e.Type)
+	})
+
+	send := func(eventType, paymentStatus string) int {
+		payload := fmt.Sprintf(`{"id":"evt_test","object":"event","api_version":%q,"type":%q,"data":{"object":{"id":"cs_test","object":"checkout.session","payment_status":%q,"amount_total":1000,"currency":"usd","metadata":{"OUT_TRADE_NO":"order_112233"}}}}`,
+			stripe.APIVersion, eventType, paymentStatus)
+		return stripeTestNotify(c, secret, payload)
+	}
+
+	send("checkout.session.completed", "unpaid")
+	send("checkout.session.async_payment_failed", "unpaid")
+	send("checkout.session.async_payment_succeeded", "paid")
+	send("checkout.session.expired", "unpaid")
+	if code := send("checkout.session.completed", "paid"); code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", code)
+	}
+
+	if len(fulfilled) != 2 {
+		t.Errorf("expected 2 fulfillments, got %v", fulfilled)
+	}
+	expected := []paykit.NotifyEventType{paykit.NOTIFY_EVENT_FAILED, paykit.NOTIFY_EVENT_PAID, paykit.NOTIFY_EVENT_EXPIRED, paykit.NOTIFY_EVENT_PAID}
+	if !slices.Equal(events, expected) {
+		t.Errorf("expected events %v, got %v", expected, events)
+	}
+}
+
+// clear && go test ./test -v -run TestStripeNotifySubscription
+func TestStripeNotifySubscription(t *testing.T) {
+	const secret = "whsec_test"
+	var events []*paykit.NotifyEvent
+	c, err := paykit.NewStripeClient(paykit.StripeConfig{
+		PaymentKey:     "Stripe 1",
+		EndpointSecret: secret,
+	}, func(s string) {})
+	if err != nil {
+		t.Fatalf("NewStripeClient error: %v", err)
+	}
+	c.SetEventHandler(func(e *paykit.NotifyEvent) {
+		events = append(events, e)
+	})
+
+	invoice := func(eventType, billingReason string) string {
+		return fmt.Sprintf(`{"id":"evt_test","object":"event","api_version":%q,"type":%q,"data":{"object":{"id":"in_test","object":"invoice","billing_reason":%q,"amount_paid":1000,"currency":"usd","parent":{"type":"subscription_details","subscription_details":{"metadata":{"OUT_TRADE_NO":"order_sub"},"subscription":"sub_test"}}}}}`,
+			stripe.APIVersion, eventType, billingReason)
+	}
+	stripeTestNotify(c, secret, invoice("invoice.paid", "subscription_create"))
+	stripeTestNotify(c, secret, invoice("invoice.paid", "subscription_cycle"))
+	stripeTestNotify(c, secret, invoice("invoice.payment_failed", "subscription_cycle"))
+	stripeTestNotify(c, secret, fmt.Sprintf(`{"id":"evt_test","object":"event","api_version":%q,"type":"customer.subscription.deleted","data":{"object":{"id":"sub_test","object":"subscription","currency":"usd","metadata":{"OUT_TRADE_NO":"order_sub"}}}}`, stripe.APIVersion))
+
+	expected := []paykit.NotifyEventType{paykit.NOTIFY_EVENT_RENEWED, paykit.NOTIFY_EVENT_FAILED, paykit.NOTIFY_EVENT_CANCELLED}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+	for i, e := range events {
+		if e.Type != expected[i] || e.OutTradeNo != "order_sub" || e.TradeNo != "sub_test" {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	}
+}
+
+// stripeTestNotify 签名 payload 后调用 Notify，返回响应状态码
+func stripeTestNotify(c *paykit.StripeClient, secret, payload string) int {
+	w := httptest.NewRecorder()
+	c.Notify(w, stripetest.WebhookRequest([]byte(payload), secret))
+	return w.Code
+}
+
+// clear && go test ./test -v -run TestStripeIdempotencyKey
+func TestStripeIdempotencyKey(t *testing.T) {
+	ctx := gctx.New()
+	keys := map[string][]string{}
+	stripeTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		keys[r.URL.Path] = append(keys[r.URL.Path], r.Header.Get("Idempotency-Key"))
+		switch r.URL.Path {
+		case "/v1/checkout/sessions":
+			w.Write([]byte(`{"id":"cs_test","object":"checkout.session","url":"https://checkout.stripe.com/c/pay/cs_test","expires_at":1735689600}`))
+		case "/v1/payment_intents/search":
+			w.Write([]byte(`{"object":"search_result","data":[{"id":"pi_test","object":"payment_intent"}],"has_more":false}`))
+		case "/v1/refunds":
+			w.Write([]byte(`{"id":"re_test","object":"refund","amount":500,"currency":"usd","status":"succeeded"}`))
+		}
+	})
+
+	c, err := paykit.NewStripeClient(paykit.StripeConfig{PaymentKey: "Stripe 1", StripeKey: "sk_test"}, func(s string) {})
+	if err != nil {
+		t.Fatalf("NewStripeClient error: %v", err)
+	}
+	req := &paykit.TradePreCreateReq{
+		ProductSubject: "Subject",
+		OutTradeNo:     "order_112233",
+		TotalAmount:    1000,
+		Currency:       paykit.CurrencyUSD,
+	}
+	for range 2 {
+		if _, err = c.TradePrecreate(ctx, req); err != nil {
+			t.Fatalf("TradePrecreate error: %v", err)
+		}
+	}
+	req.TotalAmount = 2000
+	if _, err = c.TradePrecreate(ctx, req); err != nil {
+		t.Fatalf("TradePrecreate error: %v", err)
+	}
+	req.Extra = paykit.StripeExtraForTradePreCreateReq{IdempotencyKey: "custom_key"}
+	if _, err = c.TradePrecreate(ctx, req); err != nil {
+		t.Fatalf("TradePrecreate error: %v", err)
+	}
+	sessionKeys := keys["/v1/checkout/sessions"]
+	if len(sessionKeys) != 4 || sessionKeys[0] == "" || sessionKeys[0] != sessionKeys[1] || sessionKeys[1] == sessionKeys[2] || sessionKeys[3] != "custom_key" {
+		t.Errorf("unexpected checkout session idempotency keys: %v", sessionKeys)
+	}
+
+	re, err := c.TradeRefund(ctx, "order_112233", 500)
+	if err != nil {
+		t.Fatalf("TradeRefund error: %v", err)
+	}
+	if re.PaymentIntentID != "pi_test" || re.Status != "succeeded" {
+		t.Errorf("unexpected refund result: %+v", re)
+	}
+	if refundKeys := keys["/v1/refunds"]; len(refundKeys) != 1 || refundKeys[0] == "" {
+		t.Errorf("unexpected refund idempotency keys: %v", refundKeys)
+	}
+}
+
+// clear && go test ./test -v -run TestStripeIdempotencyExpiresAt
+func TestStripeIdempotencyExpiresAt(t *testing.T) {
+	ctx := gctx.New()
+	var keys, expiresAt []string
+	stripeTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		expiresAt = append(expiresAt, r.PostForm.Get("expires_at"))
+		w.Write([]byte(`{"id":"cs_test","object":"checkout.session","url":"https://checkout.stripe.com/c/pay/cs_test","expires_at":1735693200}`))
+	})
+
+	c, err := paykit.NewStripeClient(paykit.StripeConfig{
+		PaymentKey:      "Stripe 1",
+		StripeKey:       "sk_test",
+		CheckoutOptions: paykit.StripeCheckoutOptions{ExpiresIn: 3600},
+	}, func(s string) {})
+	if err != nil {
+		t.Fatalf("NewStripeClient error: %v", err)
+	}
+	createdAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	req := &paykit.TradePreCreateReq{
+		ProductSubject: "Subject",
+		OutTradeNo:     "order_112233",
+		TotalAmount:    1000,
+		Currency:       paykit.CurrencyUSD,
+		Extra:          paykit.StripeExtraForTradePreCreateReq{CreatedAt: createdAt},
+	}
+	// 未设置过期时间的订单无需传入创建时间
+	noExpiry, err := paykit.NewStripeClient(paykit.StripeConfig{PaymentKey: "Stripe 1", StripeKey: "sk_test"}, func(s string) {})
+	if err != nil {
+		t.Fatalf("NewStripeClient error: %v", err)
+	}
+	noExpiryReq := *req
+	noExpiryReq.Extra = nil
+
+	// 重试间隔跨越秒级边界，相同的创建时间仍发送相同的 expires_at 及幂等键，未设置过期时间时幂等键同样不变
+	for range 2 {
+		if _, err = c.TradePrecreate(ctx, req); err != nil {
+			t.Fatalf("TradePrecreate error: %v", err)
+		}
+		if _, err = noExpiry.TradePrecreate(ctx, &noExpiryReq); err != nil {
+			t.Fatalf("TradePrecreate error: %v", err)
+		}
+		time.Sleep(1100 * time.Millisecond)
+	}
+	want := fmt.Sprint(createdAt.Add(time.Hour).Unix())
+	if expiresAt[0] != want || expiresAt[2] != want || keys[0] == "" || keys[0] != keys[2] {
+		t.Errorf("retry should send identical expires_at and idempotency key, got expires_at %v, keys %v", expiresAt, keys)
+	}
+	if expiresAt[1] != "" || expiresAt[3] != "" || keys[1] == "" || keys[1] != keys[3] {
+		t.Errorf("retry without created at should send an identical idempotency key, got expires_at %v, keys %v", expiresAt, keys)
+	}
+
+	// 不同的过期时间使用不同的幂等键，避免 Stripe 拒绝参数不一致的同键请求
+	req.Extra = paykit.StripeExtraForTradePreCreateReq{CreatedAt: createdAt.Add(time.Minute)}
+	if _, err = c.TradePrecreate(ctx, req); err != nil {
+		t.Fatalf("TradePrecreate error: %v", err)
+	}
+	if keys[4] == keys[0] {
+		t.Errorf("expected a new idempotency key for a different expires_at, got %v", keys)
+	}
+
+	// 设置过期时间但未传入创建时间时，每次重试的 expires_at 不同，拒绝创建
+	req.Extra = paykit.StripeExtraForTradePreCreateReq{}
+	if _, err = c.TradePrecreate(ctx, req); err == nil || len(keys) != 5 {
+		t.Errorf("expected an error without created at, got %v, requests: %d", err, len(keys))
+	}
+	// 指定幂等键时由调用方负责，使用当前时间
+	req.Extra = paykit.StripeExtraForTradePreCreateReq{IdempotencyKey: "custom_key"}
+	if _, err = c.TradePrecreate(ctx, req); err != nil || keys[5] != "custom_key" || expiresAt[5] == "" {
+		t.Errorf("unexpected custom key request: %v, keys %v, expires_at %v", err, keys, expiresAt)
+	}
+}
+
+// stripeTestBackend 将 Stripe API 请求转发至本地模拟服务，测试结束后恢复
+func stripeTestBackend(t *testing.T, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	backend := stripe.GetBackend(stripe.APIBackend)
+	stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL:               stripe.String(server.URL),
+		MaxNetworkRetries: stripe.Int64(0),
+	}))
+	t.Cleanup(func() {
+		stripe.SetBackend(stripe.APIBackend, backend)
+		server.Close()
+	})
+}
+
+// clear && go test ./test -v -run TestStripeNotifyRefundDispute
+func TestStripeNotifyRefundDispute(t *testing.T) {
+	const secret = "whsec_test"
+	stripeTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/payment_intents/pi_test" {
+			w.Write([]byte(`{"id":"pi_test","object":"payment_intent","metadata":{"OUT_TRADE_NO":"order_112233"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	var events []*paykit.NotifyEvent
+	c, err := paykit.NewStripeClient(paykit.StripeConfig{
+		PaymentKey:     "Stripe 1",
+		StripeKey:      "sk_test",
+		EndpointSecret: secret,
+	}, func(s string) {})
+	if err != nil {
+		t.Fatalf("NewStripeClient error: %v", err)
+	}
+	c.SetRefundDisputeHandler(func(e *paykit.NotifyEvent) {
+		events = append(events, e)
+	})
+
+	event := func(eventType, object string) string {
+		return fmt.Sprintf(`{"id":"evt_test","object":"event","api_version":%q,"type":%q,"data":{"object":%s}}`, stripe.APIVersion, eventType, object)
+	}
+	stripeTestNotify(c, secret, event("charge.refunded", `{"id":"ch_test","object":"charge","amount_refunded":500,"currency":"usd","payment_intent":"pi_test","status":"succeeded"}`))
+	stripeTestNotify(c, secret, event("refund.updated", `{"id":"re_test","object":"refund","amount":500,"currency":"usd","payment_intent":"pi_test","status":"failed","metadata":{"OUT_TRADE_NO":"order_112233"}}`))
+	stripeTestNotify(c, secret, event("charge.dispute.created", `{"id":"dp_test","object":"dispute","amount":1000,"currency":"usd","charge":"ch_test","payment_intent":"pi_test","reason":"fraudulent","status":"needs_response"}`))
+	stripeTestNotify(c, secret, event("charge.dispute.closed", `{"id":"dp_test","object":"dispute","amount":1000,"currency":"usd","charge":"ch_test","payment_intent":"pi_test","reason":"fraudulent","status":"lost"}`))
+
+	expected := []paykit.NotifyEventType{paykit.NOTIFY_EVENT_REFUNDED, paykit.NOTIFY_EVENT_REFUND_UPDATED, paykit.NOTIFY_EVENT_DISPUTE_CREATED, paykit.NOTIFY_EVENT_DISPUTE_CLOSED}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+	for i, e := range events {
+		if e.Type != expected[i] || e.OutTradeNo != "order_112233" || e.TradeNo != "pi_test" {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	}
+	if ex := events[3].Extra.(paykit.StripeExtraForRefundDisputeEvent); ex.Status != "lost" || ex.Amount != 1000 {
+		t.Errorf("unexpected dispute extra: %+v", ex)
+	}
+}
+
+// clear && go test ./test -v -run TestStripeUIMode
+func TestStripeUIMode(t *testing.T) {
+	ctx := gctx.New()
+	const secret = "whsec_test"
+	forms := map[string]url.Values{}
+	stripeTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms[r.URL.Path] = r.PostForm
+		switch r.URL.Path {
+		case "/v1/checkout/sessions":
+			w.Write([]byte(`{"id":"cs_test","object":"checkout.session","ui_mode":"embedded","client_secret":"cs_test_secret","return_url":"https://example.com/return?session_id={CHECKOUT_SESSION_ID}"}`))
+		case "/v1/payment_intents":
+			w.Write([]byte(`{"id":"pi_test","object":"payment_intent","client_secret":"pi_test_secret"}`))
+		}
+	})
+
+	var fulfilled []string
+	c, err := paykit.NewStripeClient(paykit.StripeConfig{
+		PaymentKey:     "Stripe 1",
+		StripeKey:      "sk_test",
+		EndpointSecret: secret,
+		SuccessURL:     "https://example.com/success",
+		CancelURL:      "https://example.com/cancel",
+		ReturnURL:      "https://example.com/return?session_id={CHECKOUT_SESSION_ID}",
+	}, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("NewStripeClient error: %v", err)
+	}
+	req := &paykit.TradePreCreateReq{
+		ProductSubject: "Subject",
+		OutTradeNo:     "order_112233",
+		TotalAmount:    1000,
+		Currency:       paykit.CurrencyUSD,
+		Extra:          paykit.StripeExtraForTradePreCreateReq{UIMode: paykit.STRIPE_UI_MODE_EMBEDDED},
+	}
+
+	// 嵌入式 Checkout
+	res, err := c.TradePrecreate(ctx, req)
+	if err != nil {
+		t.Fatalf("TradePrecreate error: %v", err)
+	}
+	form := forms["/v1/checkout/sessions"]
+	if form.Get("ui_mode") != "embedded" || form.Get("return_url") == "" || form.Get("success_url") != "" || form.Get("cancel_url") != "" {
+		t.Errorf("unexpected embedded checkout params: %v", form)
+	}
+	if ex := res.Extra.(paykit.StripeExtraForTradePreCreateRes); ex.ClientSecret != "cs_test_secret" || ex.ReturnURL == "" {
+		t.Errorf("unexpected embedded checkout result: %+v", ex)
+	}
+
+	// Elements
+	req.Extra = paykit.StripeExtraForTradePreCreateReq{UIMode: paykit.STRIPE_UI_MODE_ELEMENTS}
+	if res, err = c.TradePrecreate(ctx, req); err != nil {
+		t.Fatalf("TradePrecreate error: %v", err)
+	}
+	form = forms["/v1/payment_intents"]
+	if form.Get("amount") != "1000" || form.Get("metadata[OUT_TRADE_NO]") != "order_112233" || form.Get("payment_method_types[1]") != "wechat_pay" || form.Get("payment_method_options[wechat_pay][client]") != "web" {
+		t.Errorf("unexpected payment intent params: %v", form)
+	}
+	req.Extra = paykit.StripeExtraForTradePreCreateReq{UIMode: paykit.STRIPE_UI_MODE_ELEMENTS, CheckoutOptions: paykit.StripeCheckoutOptions{DynamicPaymentMethods: true}}
+	if _, err = c.TradePrecreate(ctx, req); err != nil {
+		t.Fatalf("TradePrecreate error: %v", err)
+	}
+	if form = forms["/v1/payment_intents"]; form.Get("automatic_payment_methods[enabled]") != "true" || form.Has("payment_method_types[0]") {
+		t.Errorf("unexpected dynamic payment intent params: %v", form)
+	}
+	if ex := res.Extra.(paykit.StripeExtraForTradePreCreateRes); ex.PaymentIntentID != "pi_test" || ex.ClientSecret != "pi_test_secret" {
+		t.Errorf("unexpected payment intent result: %+v", ex)
+	}
+	req.Extra = paykit.StripeExtraForTradePreCreateReq{UIMode: paykit.STRIPE_UI_MODE_ELEMENTS, Mode: paykit.STRIPE_MODE_SUBSCRIPTION}
+	if _, err = c.TradePrecreate(ctx, req); err == nil {
+		t.Error("expected error for subscription in elements mode")
+	}
+
+	// 仅 Elements 模式创建的 PaymentIntent 在 payment_intent.succeeded 时履约
+	paymentIntent := func(metadata string) string {
+		return fmt.Sprintf(`{"id":"evt_test","object":"event","api_version":%q,"type":"payment_intent.succeeded","data":{"object":{"id":"pi_test","object":"payment_intent","amount":1000,"currency":"usd","status":"succeeded","metadata":%s}}}`, stripe.APIVersion, metadata)
+	}
+	stripeTestNotify(c, secret, paymentIntent(`{"OUT_TRADE_NO":"order_checkout"}`))
+	if code := stripeTestNotify(c, secret, paymentIntent(fmt.Sprintf(`{"OUT_TRADE_NO":"order_112233","PAYKIT_FLOW":%q}`, form.Get("metadata[PAYKIT_FLOW]")))); code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", code)
+	}
+	if !slices.Equal(fulfilled, []string{"order_112233"}) {
+		t.Errorf("unexpected fulfillments: %v", fulfilled)
+	}
+}
+
+// clear && go test ./test -v -run TestStripeManualCapture
+func TestStripeManualCapture(t *testing.T) {
+	ctx := gctx.New()
+	const secret = "whsec_test"
+	forms := map[string]url.Values{}
+	stripeTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms[r.URL.Path] = r.PostForm
+		switch r.URL.Path {
+		case "/v1/checkout/sessions":
+			w.Write([]byte(`{"id":"cs_test","object":"checkout.session","url":"https://checkout.stripe.com/c/pay/cs_test"}`))
+		case "/v1/payment_intents/search":
+			w.Write([]byte(`{"object":"search_result","data":[{"id":"pi_test","object":"payment_intent"}],"has_more":false}`))
+		case "/v1/payment_intents/pi_test/capture":
+			w.Write([]byte(`{"id":"pi_test","object":"payment_intent","amount_received":800,"currency":"usd","status":"succeeded"}`))
+		case "/v1/payment_intents/pi_test/cancel":
+			w.Write([]byte(`{"id":"pi_test","object":"payment_intent","currency":"usd","status":"canceled"}`))
+		}
+	})
+
+	var (
+		fulfilled []string
+		events    []paykit.NotifyEventType
+	)
+	c, err := paykit.NewStripeClient(paykit.StripeConfig{
+		PaymentKey:     "Stripe 1",
+		StripeKey:      "sk_test",
+		EndpointSecret: secret,
+	}, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("NewStripeClient error: %v", err)
+	}
+	c.SetEventHandler(func(e *paykit.NotifyEvent) {
+		events = append(events, e.Type)
+	})
+
+	_, err = c.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+		ProductSubject: "Subject",
+		OutTradeNo:     "order_112233",
+		TotalAmount:    1000,
+		Currency:       paykit.CurrencyUSD,
+		Extra: paykit.StripeExtraForTradePreCreateReq{
+			CheckoutOptions: paykit.StripeCheckoutOptions{CaptureMethod: "manual"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("TradePrecreate error: %v", err)
+	}
+	if form := forms["/v1/checkout/sessions"]; form.Get("payment_intent_data[capture_method]") != "manual" {
+		t.Errorf("unexpected checkout session params: %v", form)
+	}
+
+	res, err := c.Capture(ctx, "order_112233", 800)
+	if err != nil {
+		t.Fatalf("Capture error: %v", err)
+	}
+	if res.AmountReceived != 800 || res.Status != "succeeded" || forms["/v1/payment_intents/pi_test/capture"].Get("amount_to_capture") != "800" {
+		t.Errorf("unexpected capture result: %+v", res)
+	}
+	if res, err = c.CancelAuthorization(ctx, "order_112233"); err != nil || res.Status != "canceled" {
+		t.Errorf("unexpected cancel authorization result: %+v, %v", res, err)
+	}
+
+	paymentIntent := func(eventType, status string) string {
+		return fmt.Sprintf(`{"id":"evt_test","object":"event","api_version":%q,"type":%q,"data":{"object":{"id":"pi_test","object":"payment_intent","amount":1000,"amount_capturable":1000,"capture_method":"manual","currency":"usd","status":%q,"metadata":{"OUT_TRADE_NO":"order_112233"}}}}`,
+			stripe.APIVersion, eventType, status)
+	}
+	stripeTestNotify(c, secret, paymentIntent("payment_intent.amount_capturable_updated", "requires_capture"))
+	stripeTestNotify(c, secret, paymentIntent("payment_intent.succeeded", "succeeded"))
+	stripeTestNotify(c, secret, paymentIntent("payment_intent.canceled", "canceled"))
+
+	if !slices.Equal(fulfilled, []string{"order_112233"}) {
+		t.Errorf("unexpected fulfillments: %v", fulfilled)
+	}
+	expected := []paykit.NotifyEventType{paykit.NOTIFY_EVENT_AUTHORIZED, paykit.NOTIFY_EVENT_PAID, paykit.NOTIFY_EVENT_CLOSED}
+	if !slices.Equal(events, expected) {
+		t.Errorf("expected events %v, got %v", expected, events)
+	}
+}
+
+// clear && go test ./test -v -run TestStripeNotifyFixtures
+func TestStripeNotifyFixtures(t *testing.T) {
+	const secret = "whsec_test"
+	order := stripetest.Order{OutTradeNo: "order_112233", Amount: 1000}
+	free := stripetest.Order{OutTradeNo: "order_free", Amount: 1000}
+	// 争议事件不含订单号，通过查询 PaymentIntent 获取
+	var lookups int
+	stripeTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/v1/payment_intents/"+stripetest.PaymentIntentID(order) {
+			lookups++
+			w.Write(stripetest.PaymentIntent(order))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	var (
+		fulfilled []string
+		events    []*paykit.NotifyEvent
+	)
+	c, err := paykit.NewStripeClient(paykit.StripeConfig{
+		PaymentKey:     "Stripe 1",
+		EndpointSecret: secret,
+	}, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("NewStripeClient error: %v", err)
+	}
+	c.SetEventHandler(func(e *paykit.NotifyEvent) {
+		events = append(events, e)
+	})
+	c.SetRefundDisputeHandler(func(e *paykit.NotifyEvent) {
+		events = append(events, e)
+	})
+
+	payloads := [][]byte{
+		stripetest.CheckoutSessionCompleted(order, false),
+		stripetest.CheckoutSessionAsyncPaymentSucceeded(order),
+		stripetest.CheckoutSessionCompleted(order, true),
+		stripetest.CheckoutSessionNoPaymentRequired(free),
+		stripetest.CheckoutSessionExpired(order),
+		stripetest.ChargeRefunded(order, 500),
+		stripetest.ChargeDisputeCreated(order),
+	}
+	for _, payload := range payloads {
+		if code := stripeTestNotify(c, secret, string(payload)); code != http.StatusOK {
+			t.Errorf("expected status 200, got %d, payload: %s", code, payload)
+		}
+	}
+	w := httptest.NewRecorder()
+	c.Notify(w, stripetest.WebhookRequest(payloads[0], "whsec_wrong"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for wrong secret, got %d", w.Code)
+	}
+
+	if !slices.Equal(fulfilled, []string{"order_112233", "order_112233", "order_free"}) {
+		t.Errorf("unexpected fulfillments: %v", fulfilled)
+	}
+	expected := []paykit.NotifyEventType{paykit.NOTIFY_EVENT_PAID, paykit.NOTIFY_EVENT_PAID, paykit.NOTIFY_EVENT_PAID, paykit.NOTIFY_EVENT_EXPIRED, paykit.NOTIFY_EVENT_REFUNDED, paykit.NOTIFY_EVENT_DISPUTE_CREATED}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+	for i, e := range events {
+		no := "order_112233"
+		if i == 2 {
+			no = "order_free"
+		}
+		if e.Type != expected[i] || e.OutTradeNo != no {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	}
+	if ex := events[4].Extra.(paykit.StripeExtraForRefundDisputeEvent); ex.Amount != 500 || ex.Currency != "usd" {
+		t.Errorf("unexpected refund extra: %+v", ex)
+	}
+	if lookups != 1 {
+		t.Errorf("expected the dispute to look up its PaymentIntent once, got %d", lookups)
+	}
+}
+
+// clear && go test ./test -v -run TestStripeCheckoutOptions
+func TestStripeCheckoutOptions(t *testing.T) {
+	ctx := gctx.New()
+	var forms []url.Values
+	stripeTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		forms = append(forms, r.PostForm)
+		w.Write([]byte(`{"id":"cs_test","object":"checkout.session","url":"https://checkout.stripe.com/c/pay/cs_test"}`))
+	})
+	newClient := func(options paykit.StripeCheckoutOptions) *paykit.StripeClient {
+		c, err := paykit.NewStripeClient(paykit.StripeConfig{PaymentKey: "Stripe 1", StripeKey: "sk_test", CheckoutOptions: options}, func(s string) {})
+		if err != nil {
+			t.Fatalf("NewStripeClient error: %v", err)
+		}
+		return c
+	}
+	precreate := func(c *paykit.StripeClient, ex paykit.StripeExtraForTradePreCreateReq) url.Values {
+		_, err := c.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+			ProductSubject: "Subject",
+			OutTradeNo:     "order_112233",
+			TotalAmount:    1000,
+			Currency:       paykit.CurrencyUSD,
+			Extra:          ex,
+		})
+		if err != nil {
+			t.Fatalf("TradePrecreate error: %v", err)
+		}
+		return forms[len(forms)-1]
+	}
+	expect := func(form url.Values, want map[string]string) {
+		t.Helper()
+		for k, v := range want {
+			if got := form.Get(k); got != v {
+				t.Errorf("%s: expected %q, got %q", k, v, got)
+			}
+		}
+	}
+
+	c := newClient(paykit.StripeCheckoutOptions{
+		PaymentMethodTypes:       []string{"card"},
+		Locale:                   "en",
+		ExpiresIn:                3600,
+		AllowPromotionCodes:      stripe.Bool(true),
+		AutomaticTax:             stripe.Bool(true),
+		BillingAddressCollection: "required",
+	})
+	createdAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 配置中的默认选项
+	form := precreate(c, paykit.StripeExtraForTradePreCreateReq{CreatedAt: createdAt})
+	expect(form, map[string]string{
+		"payment_method_types[0]":                    "card",
+		"payment_method_options[wechat_pay][client]": "",
+		"locale":                     "en",
+		"expires_at":                 fmt.Sprint(createdAt.Add(time.Hour).Unix()),
+		"allow_promotion_codes":      "true",
+		"automatic_tax[enabled]":     "true",
+		"billing_address_collection": "required",
+	})
+
+	// 按订单覆盖的选项优先，未覆盖的选项沿用配置；包含微信支付时设置 client=web
+	form = precreate(c, paykit.StripeExtraForTradePreCreateReq{
+		CreatedAt: createdAt,
+		CheckoutOptions: paykit.StripeCheckoutOptions{
+			PaymentMethodTypes:  []string{"card", "wechat_pay"},
+			Locale:              "zh",
+			ExpiresIn:           1800,
+			AllowPromotionCodes: stripe.Bool(false),
+		},
+	})
+	expect(form, map[string]string{
+		"payment_method_types[0]":                    "card",
+		"payment_method_types[1]":                    "wechat_pay",
+		"payment_method_options[wechat_pay][client]": "web",
+		"locale":                     "zh",
+		"expires_at":                 fmt.Sprint(createdAt.Add(30 * time.Minute).Unix()),
+		"allow_promotion_codes":      "false",
+		"automatic_tax[enabled]":     "true",
+		"billing_address_collection": "required",
+	})
+
+	// 未配置支付方式时默认使用 card, wechat_pay, alipay，不发送其余未设置的选项
+	form = precreate(newClient(paykit.StripeCheckoutOptions{}), paykit.StripeExtraForTradePreCreateReq{})
+	expect(form, map[string]string{
+		"payment_method_types[0]":                    "card",
+		"payment_method_types[1]":                    "wechat_pay",
+		"payment_method_types[2]":                    "alipay",
+		"payment_method_options[wechat_pay][client]": "web",
+	})
+	for _, k := range []string{"locale", "expires_at", "allow_promotion_codes", "automatic_tax[enabled]", "billing_address_collection"} {
+		if form.Has(k) {
+			t.Errorf("unexpected %s: %q", k, form.Get(k))
+		}
+	}
+
+	// 显式启用动态支付方式时不发送支付方式，按订单启用时覆盖配置中的支付方式
+	for _, form = range []url.Values{
+		precreate(newClient(paykit.StripeCheckoutOptions{DynamicPaymentMethods: true}), paykit.StripeExtraForTradePreCreateReq{}),
+		precreate(c, paykit.StripeExtraForTradePreCreateReq{CreatedAt: createdAt, CheckoutOptions: paykit.StripeCheckoutOptions{DynamicPaymentMethods: true}}),
+	} {
+		if form.Has("payment_method_types[0]") || form.Has("payment_method_options[wechat_pay][client]") {
+			t.Errorf("unexpected payment methods for dynamic payment methods: %v", form)
+		}
+	}
+}
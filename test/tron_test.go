@@ -2,10 +2,17 @@ package test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,10 +25,11 @@ func TestTron(t *testing.T) {
 
 	config := paykit.TronConfig{
 		PaymentKey:   "Tron 1",
+		Network:      paykit.TRON_NETWORK_NILE,
 		APIKey:       "",
 		Address:      os.Getenv("CollectionAddress"),
 		OrderTimeout: 60,
-		AcceptTokens: []paykit.TokenSymbol{paykit.USDC_TRC20, paykit.USDT_TRC20},
+		AcceptTokens: []paykit.TokenSymbol{paykit.USDT_TRC20}, // Nile 测试网仅内置 USDT 合约
 	}
 	tronClient, err := paykit.NewTron(config, func(s string) {})
 	if err != nil {
@@ -92,7 +100,7 @@ func TestTron(t *testing.T) {
 	t.Logf("TradePrecreate out_trade_no: %s, total_amount: %s", r1.OutTradeNo, ta1)
 
 	// 删除 key
-	tronClient.Cacha().Remove(ctx, ta1)
+	tronClient.Release(r1.OutTradeNo)
 
 	// 第二个请求
 	r2, err := tronClient.TradePrecreate(ctx, req2)
@@ -109,10 +117,11 @@ func TestTronTransactions(t *testing.T) {
 	ctx := gctx.New()
 
 	config := paykit.TronConfig{
+		Network:      paykit.TRON_NETWORK_NILE,
 		APIKey:       "",
 		Address:      os.Getenv("CollectionAddress"),
 		OrderTimeout: 60,
-		AcceptTokens: []paykit.TokenSymbol{paykit.USDC_TRC20, paykit.USDT_TRC20},
+		AcceptTokens: []paykit.TokenSymbol{paykit.USDT_TRC20}, // Nile 测试网仅内置 USDT 合约
 	}
 	tronClient, err := paykit.NewTron(config, func(s string) {
 		t.Logf("Fulfill checkout, outTradeNo: %s", s)
@@ -129,12 +138,19 @@ func TestTronTransactions(t *testing.T) {
 	t.Log("Start Success")
 
 	// 模拟数据, 可用测试钱包转入相同金额测试
-	outTradeNo := "12345678"
-	amount := 1.23 // 必须2位小数，和内部函数逻辑保持一致
-	err = tronClient.Cacha().Set(ctx, amount, outTradeNo, 0)
+	r, err := tronClient.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+		OutTradeNo:  "12345678",
+		TotalAmount: 123, // 1.23 USD
+		Currency:    paykit.CurrencyUSD,
+		Extra: paykit.TronExtraForTradePreCreateReq{
+			TokenSymbol: paykit.USDT_TRC20,
+		},
+	})
 	if err != nil {
-		t.Errorf("Set cache error: %v", err)
+		t.Errorf("TradePrecreate error: %v", err)
+		return
 	}
+	t.Logf("TradePrecreate out_trade_no: %s, total_amount: %s", r.OutTradeNo, r.Extra.(paykit.TronExtraForTradePreCreateRes).TotalAmountString)
 
 	ctx, cancel := context.WithTimeout(ctx, 300*time.Second)
 	defer cancel()
@@ -155,3 +171,1091 @@ func TestTronTransactions(t *testing.T) {
 	}
 
 }
+
+// clear && go test ./test -v -run TestTronAmountStrategy
+func TestTronAmountStrategy(t *testing.T) {
+	cases := []struct {
+		strategy paykit.TronAmountStrategy
+		amount   int64
+		expected []int64
+	}{
+		{paykit.TronAmountStrategy{MaxDeviation: 0.03}, 1_000_000, []int64{1_000_000, 1_010_000, 1_020_000, 1_030_000}},
+		{paykit.TronAmountStrategy{Step: 0.001, MaxDeviation: 0.002}, 1_000_000, []int64{1_000_000, 1_001_000, 1_002_000}},
+		{paykit.TronAmountStrategy{MaxDeviation: 1, MaxDeviationPercent: 2}, 1_000_000, []int64{1_000_000, 1_010_000, 1_020_000}},
+		{paykit.TronAmountStrategy{MaxDeviation: 0.05, Direction: paykit.TRON_AMOUNT_DIRECTION_DOWN}, 20_000, []int64{20_000, 10_000}},
+	}
+	for _, c := range cases {
+		if got := c.strategy.Candidates(c.amount, 6); !slices.Equal(got, c.expected) {
+			t.Errorf("strategy %+v: expected %v, got %v", c.strategy, c.expected, got)
+		}
+	}
+	if got := (paykit.TronAmountStrategy{}).Candidates(1_000_000, 6); len(got) != 101 || got[100] != 2_000_000 {
+		t.Errorf("unexpected default candidates: %d, last %d", len(got), got[len(got)-1])
+	}
+	got := paykit.TronAmountStrategy{MaxDeviation: 0.02, Direction: paykit.TRON_AMOUNT_DIRECTION_RANDOM}.Candidates(1_000_000, 6)
+	slices.Sort(got)
+	if !slices.Equal(got, []int64{980_000, 990_000, 1_000_000, 1_010_000, 1_020_000}) {
+		t.Errorf("unexpected random candidates: %v", got)
+	}
+}
+
+// clear && go test ./test -v -run TestTronAllocate
+func TestTronAllocate(t *testing.T) {
+	ctx := gctx.New()
+	tronClient, err := paykit.NewTron(paykit.TronConfig{
+		Network:      paykit.TRON_NETWORK_NILE,
+		Address:      "TJRabPrwbZy45sbavfcjinPJC18kjpRTv8",
+		AcceptTokens: []paykit.TokenSymbol{paykit.USDT_TRC20},
+		Allocator:    paykit.TronAmountStrategy{MaxDeviation: 0.49},
+	}, func(s string) {})
+	if err != nil {
+		t.Fatalf("NewTron error: %v", err)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		amounts []string
+		errs    int
+	)
+	for i := range 60 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := tronClient.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+				OutTradeNo:  fmt.Sprintf("%d", 1001+i),
+				TotalAmount: 1000,
+				Currency:    paykit.CurrencyUSD,
+				Extra:       paykit.TronExtraForTradePreCreateReq{TokenSymbol: paykit.USDT_TRC20},
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs++
+				return
+			}
+			amounts = append(amounts, r.Extra.(paykit.TronExtraForTradePreCreateRes).TotalAmountString)
+		}()
+	}
+	wg.Wait()
+
+	slices.Sort(amounts)
+	if len(amounts) != 50 || errs != 10 || len(slices.Compact(slices.Clone(amounts))) != 50 {
+		t.Fatalf("expected 50 unique amounts and 10 errors, got %d amounts, %d errors", len(amounts), errs)
+	}
+	if amounts[0] != "10.00" || amounts[49] != "10.49" {
+		t.Errorf("unexpected amount range: %s ~ %s", amounts[0], amounts[49])
+	}
+
+	// 释放后可重新分配
+	if !tronClient.Release("1001") {
+		t.Fatal("expected order 1001 to be released")
+	}
+	if _, err = tronClient.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+		OutTradeNo:  "2001",
+		TotalAmount: 1000,
+		Currency:    paykit.CurrencyUSD,
+		Extra:       paykit.TronExtraForTradePreCreateReq{TokenSymbol: paykit.USDT_TRC20},
+	}); err != nil {
+		t.Errorf("TradePrecreate after release error: %v", err)
+	}
+}
+
+// clear && go test ./test -v -run TestTronFileCursorStore
+func TestTronFileCursorStore(t *testing.T) {
+	ctx := gctx.New()
+	store := paykit.NewTronFileCursorStore(t.TempDir() + "/tron/cursor.json")
+	cursor, err := store.Load(ctx)
+	if err != nil || cursor != nil {
+		t.Fatalf("expected empty cursor, got %+v, %v", cursor, err)
+	}
+	err = store.Save(ctx, &paykit.TronScanCursor{BlockTimestamp: 1735689600000, Seen: map[string]int64{"tx1": 1735689600000}})
+	if err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+	cursor, err = store.Load(ctx)
+	if err != nil || cursor.BlockTimestamp != 1735689600000 || cursor.Seen["tx1"] != 1735689600000 {
+		t.Errorf("unexpected cursor: %+v, %v", cursor, err)
+	}
+}
+
+// clear && go test ./test -v -run TestTronGridRefresh
+func TestTronGridRefresh(t *testing.T) {
+	ctx := gctx.New()
+	const (
+		address = "TJRabPrwbZy45sbavfcjinPJC18kjpRTv8"
+		usdt    = "TXYZopYRdj2D9XRtbG411XZZ3kM5VkAeBf"
+	)
+	var (
+		mu           sync.Mutex
+		apiKeys      []string
+		fingerprints []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		apiKeys = append(apiKeys, r.Header.Get("TRON-PRO-API-KEY"))
+		fingerprints = append(fingerprints, r.URL.Query().Get("fingerprint"))
+		mu.Unlock()
+		if r.URL.Path != "/v1/accounts/"+address+"/transactions/trc20" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		now := time.Now().UnixMilli()
+		transfer := func(id, contract string, decimals int, typ, value string) string {
+			return fmt.Sprintf(`{"transaction_id":%q,"token_info":{"symbol":"USDT","address":%q,"decimals":%d,"name":"Tether USD"},"block_timestamp":%d,"from":"TFromAddress","to":%q,"type":%q,"value":%q}`,
+				id, contract, decimals, now, address, typ, value)
+		}
+		if r.URL.Query().Get("fingerprint") == "" {
+			fmt.Fprintf(w, `{"success":true,"data":[%s,%s,%s],"meta":{"fingerprint":"page2","page_size":3}}`,
+				transfer("tx_fake", "TFakeUSDTContract", 6, "Transfer", "10000000"),
+				transfer("tx_decimals", usdt, 18, "Transfer", "10000000"),
+				transfer("tx_paid", usdt, 6, "Transfer", "10000000"))
+			return
+		}
+		fmt.Fprintf(w, `{"success":true,"data":[%s,%s,%s],"meta":{"page_size":3}}`,
+			transfer("tx_paid", usdt, 6, "Transfer", "10000000"),
+			transfer("tx_approval", usdt, 6, "Approval", "10010000"),
+			transfer("tx_paid2", usdt, 6, "Transfer", "10010000"))
+	}))
+	defer server.Close()
+
+	dataSource, err := paykit.NewTronGridDataSource(paykit.TronDataSourceConfig{
+		Network:  paykit.TRON_NETWORK_NILE,
+		Endpoint: server.URL,
+		APIKey:   "test-key",
+	})
+	if err != nil {
+		t.Fatalf("NewTronGridDataSource error: %v", err)
+	}
+	cursorStore := paykit.NewTronFileCursorStore(t.TempDir() + "/cursor.json")
+	var fulfilled []string
+	tronClient, err := paykit.NewTron(paykit.TronConfig{
+		Network:      paykit.TRON_NETWORK_NILE,
+		Address:      address,
+		DataSource:   dataSource,
+		AcceptTokens: []paykit.TokenSymbol{paykit.USDT_TRC20},
+		CursorStore:  cursorStore,
+	}, func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("NewTron error: %v", err)
+	}
+	for _, no := range []string{"order_1", "order_2", "order_3"} {
+		_, err = tronClient.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+			OutTradeNo:  no,
+			TotalAmount: 1000,
+			Currency:    paykit.CurrencyUSD,
+			Extra:       paykit.TronExtraForTradePreCreateReq{TokenSymbol: paykit.USDT_TRC20},
+		})
+		if err != nil {
+			t.Fatalf("TradePrecreate error: %v", err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err = tronClient.Start(); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	tronClient.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(fulfilled, []string{"order_1", "order_2"}) {
+		t.Errorf("unexpected fulfillments: %v", fulfilled)
+	}
+	if len(apiKeys) < 2 || apiKeys[0] != "test-key" || !slices.Contains(fingerprints, "page2") {
+		t.Errorf("unexpected requests, api keys: %v, fingerprints: %v", apiKeys, fingerprints)
+	}
+	cursor, err := cursorStore.Load(ctx)
+	if err != nil || cursor == nil || cursor.BlockTimestamp == 0 || len(cursor.Seen) != 4 {
+		t.Errorf("unexpected cursor: %+v, %v", cursor, err)
+	}
+}
+
+// clear && go test ./test -v -run TestTronNodeDataSource
+func TestTronNodeDataSource(t *testing.T) {
+	ctx := gctx.New()
+	const (
+		address    = "T9yD14Nj9j7xAB4dbGeiX9h8unkKHxuWwb" // 410000000000000000000000000000000000000000
+		addressHex = "0000000000000000000000000000000000000000"
+		usdtHex    = "a614f803b6fd780986a42c78ec9c7f77e6ded13c" // TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t
+		spamHex    = "1111111111111111111111111111111111111111" // decimals() 执行失败的仿冒代币
+		topic      = "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	)
+	var (
+		now        = time.Now().UnixMilli()
+		spamCalls  atomic.Int32
+		transferTo = func(contractHex string) string {
+			return fmt.Sprintf(`{"address":%q,"topics":[%q,"000000000000000000000000%s","000000000000000000000000%s"],"data":"0000000000000000000000000000000000000000000000000000000000989680"}`,
+				contractHex, topic, usdtHex, addressHex)
+		}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/walletsolidity/getnowblock":
+			fmt.Fprintf(w, `{"block_header":{"raw_data":{"number":100,"timestamp":%d}}}`, now)
+		case "/walletsolidity/gettransactioninfobyblocknum":
+			var body struct{ Num int64 }
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.Num != 100 {
+				w.Write([]byte(`[]`))
+				return
+			}
+			fmt.Fprintf(w, `[{"id":"tx_spam","blockNumber":100,"blockTimeStamp":%d,"log":[%s]},{"id":"tx_node","blockNumber":100,"blockTimeStamp":%d,"log":[%s]}]`,
+				now, transferTo(spamHex), now, transferTo(usdtHex))
+		case "/walletsolidity/triggerconstantcontract":
+			var body struct {
+				ContractAddress string `json:"contract_address"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.ContractAddress != "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t" {
+				spamCalls.Add(1)
+				w.Write([]byte(`{"result":{"code":"CONTRACT_EXE_ERROR"},"constant_result":[]}`))
+				return
+			}
+			w.Write([]byte(`{"constant_result":["0000000000000000000000000000000000000000000000000000000000000006"]}`))
+		}
+	}))
+	defer server.Close()
+
+	dataSource, err := paykit.NewTronNodeDataSource(paykit.TronDataSourceConfig{Network: paykit.TRON_NETWORK_MAINNET, Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("NewTronNodeDataSource error: %v", err)
+	}
+	scan := func() []*paykit.TronTransfer {
+		var (
+			transfers []*paykit.TronTransfer
+			pages     int
+		)
+		for next := ""; pages == 0 || next != ""; pages++ {
+			var page []*paykit.TronTransfer
+			page, next, err = dataSource.TRC20Transfers(ctx, address, now-60_000, now, next)
+			if err != nil {
+				t.Fatalf("TRC20Transfers error: %v", err)
+			}
+			transfers = append(transfers, page...)
+		}
+		if pages != 2 {
+			t.Fatalf("unexpected pages: %d", pages)
+		}
+		return transfers
+	}
+
+	// 仿冒代币的精度查询失败时跳过该转账，不中断扫描
+	transfers := scan()
+	if len(transfers) != 1 || spamCalls.Load() != 1 {
+		t.Fatalf("unexpected transfers: %d, spam decimals calls: %d", len(transfers), spamCalls.Load())
+	}
+	v := transfers[0]
+	if v.Contract != "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t" || v.From != "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t" || v.To != address || v.Value != "10000000" || v.Decimals != 6 {
+		t.Errorf("unexpected transfer: %+v", v)
+	}
+
+	// 由 TronClient 使用时仅解析可接受代币的合约
+	_, err = paykit.NewTron(paykit.TronConfig{
+		Network:      paykit.TRON_NETWORK_MAINNET,
+		Address:      address,
+		DataSource:   dataSource,
+		AcceptTokens: []paykit.TokenSymbol{paykit.USDT_TRC20},
+	}, func(string) {})
+	if err != nil {
+		t.Fatalf("NewTron error: %v", err)
+	}
+	if transfers = scan(); len(transfers) != 1 || spamCalls.Load() != 1 {
+		t.Errorf("unexpected transfers: %d, spam decimals calls: %d", len(transfers), spamCalls.Load())
+	}
+}
+
+// clear && go test ./test -v -run TestTronNodeBlockScan
+func TestTronNodeBlockScan(t *testing.T) {
+	ctx := gctx.New()
+	const (
+		address0    = "T9yD14Nj9j7xAB4dbGeiX9h8unkKHxuWwb" // 410000000000000000000000000000000000000000
+		address0Hex = "0000000000000000000000000000000000000000"
+		address1    = "TD5gsCwxykWsLN9aPrq2TAfNjByuZKYp4E" // 412222222222222222222222222222222222222222
+		address1Hex = "2222222222222222222222222222222222222222"
+		usdtHex     = "a614f803b6fd780986a42c78ec9c7f77e6ded13c" // TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t
+		topic       = "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	)
+	var (
+		head  atomic.Int64
+		mu    sync.Mutex
+		calls = map[string]int{} // 接口路径及区块高度 -> 调用次数
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Num int64 }
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		calls[fmt.Sprintf("%s/%d", r.URL.Path, body.Num)]++
+		mu.Unlock()
+		now := head.Load()
+		switch r.URL.Path {
+		case "/walletsolidity/getnowblock":
+			fmt.Fprintf(w, `{"block_header":{"raw_data":{"number":100,"timestamp":%d}}}`, now)
+		case "/walletsolidity/gettransactioninfobyblocknum":
+			if body.Num != 100 {
+				w.Write([]byte(`[]`))
+				return
+			}
+			log := func(toHex string) string {
+				return fmt.Sprintf(`{"address":%q,"topics":[%q,"000000000000000000000000%s","000000000000000000000000%s"],"data":"989680"}`,
+					usdtHex, topic, usdtHex, toHex)
+			}
+			fmt.Fprintf(w, `[{"id":"tx_usdt0","blockNumber":100,"blockTimeStamp":%d,"log":[%s]},{"id":"tx_usdt1","blockNumber":100,"blockTimeStamp":%d,"log":[%s]}]`,
+				now, log(address0Hex), now, log(address1Hex))
+		case "/walletsolidity/getblockbynum":
+			if body.Num != 100 {
+				fmt.Fprintf(w, `{"block_header":{"raw_data":{"number":%d}}}`, body.Num)
+				return
+			}
+			fmt.Fprintf(w, `{"block_header":{"raw_data":{"number":100,"timestamp":%d}},"transactions":[{"txID":"tx_trx","ret":[{"contractRet":"SUCCESS"}],"raw_data":{"contract":[{"type":"TransferContract","parameter":{"value":{"amount":40000000,"owner_address":"41%s","to_address":"41%s"}}}]}}]}`,
+				now, usdtHex, address0Hex)
+		case "/walletsolidity/triggerconstantcontract":
+			w.Write([]byte(`{"constant_result":["0000000000000000000000000000000000000000000000000000000000000006"]}`))
+		}
+	}))
+	defer server.Close()
+
+	dataSource, err := paykit.NewTronNodeDataSource(paykit.TronDataSourceConfig{Network: paykit.TRON_NETWORK_MAINNET, Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("NewTronNodeDataSource error: %v", err)
+	}
+	var fulfilled []string
+	tronClient, err := paykit.NewTron(paykit.TronConfig{
+		Network:         paykit.TRON_NETWORK_MAINNET,
+		DataSource:      dataSource,
+		AcceptTokens:    []paykit.TokenSymbol{paykit.USDT_TRC20, paykit.TRX},
+		AddressProvider: paykit.TronAddressPool{address0, address1},
+		RateSource: paykit.TronRateFunc(func(ctx context.Context, symbol paykit.TokenSymbol) (float64, error) {
+			return 0.25, nil
+		}),
+	}, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("NewTron error: %v", err)
+	}
+
+	// 两个地址各一笔 USDT 订单，第一个地址另有一笔 TRX 订单
+	for _, order := range []struct {
+		outTradeNo string
+		symbol     paykit.TokenSymbol
+		address    string
+	}{
+		{"order_usdt0", paykit.USDT_TRC20, address0},
+		{"order_usdt1", paykit.USDT_TRC20, address1},
+		{"order_trx", paykit.TRX, address0},
+	} {
+		res, err := tronClient.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+			OutTradeNo:  order.outTradeNo,
+			TotalAmount: 1000,
+			Currency:    paykit.CurrencyUSD,
+			Extra:       paykit.TronExtraForTradePreCreateReq{TokenSymbol: order.symbol},
+		})
+		if err != nil {
+			t.Fatalf("TradePrecreate %s error: %v", order.outTradeNo, err)
+		}
+		if ex := res.Extra.(paykit.TronExtraForTradePreCreateRes); ex.Address != order.address {
+			t.Fatalf("unexpected address of %s: %s", order.outTradeNo, ex.Address)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	head.Store(time.Now().UnixMilli())
+
+	if err = tronClient.Start(); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	tronClient.Stop()
+
+	slices.Sort(fulfilled)
+	if !slices.Equal(fulfilled, []string{"order_trx", "order_usdt0", "order_usdt1"}) {
+		t.Errorf("unexpected fulfillments: %v", fulfilled)
+	}
+	// 每个区块只拉取一次，与收款地址及转账类型的数量无关
+	for key, n := range calls {
+		if !strings.HasPrefix(key, "/walletsolidity/getnowblock") && !strings.HasPrefix(key, "/walletsolidity/triggerconstantcontract") && n != 1 {
+			t.Errorf("%s called %d times", key, n)
+		}
+	}
+	if calls["/walletsolidity/getblockbynum/100"] != 1 || calls["/walletsolidity/gettransactioninfobyblocknum/100"] != 1 {
+		t.Errorf("block 100 not scanned, calls: %v", calls)
+	}
+}
+
+// clear && go test ./test -v -run TestTronAddressProvider
+func TestTronAddressProvider(t *testing.T) {
+	ctx := gctx.New()
+	const usdt = "TXYZopYRdj2D9XRtbG411XZZ3kM5VkAeBf"
+
+	// BIP39 测试助记词 "abandon abandon ... abandon about" 的账户扩展公钥 m/44'/195'/0'，
+	// m/44'/195'/0'/0/0 为 TUEZSdKsoDHQMeZwihtdoBiN46zxhGWYdH (gotron-sdk 公开的测试地址)
+	provider, err := paykit.NewTronXPubAddressProvider("xpub6D1AabNHCupeiLM65ZR9UStMhJ1vCpyV4XbZdyhMZBiJXALQtmn9p42VTQckoHVn8WNqS7dqnJokZHAHcHGoaQgmv8D45oNUKx6DZMNZBCd", 2)
+	if err != nil {
+		t.Fatalf("NewTronXPubAddressProvider error: %v", err)
+	}
+	addresses := provider.Addresses()
+	if len(addresses) != 2 || addresses[0] != "TUEZSdKsoDHQMeZwihtdoBiN46zxhGWYdH" || addresses[1] == addresses[0] {
+		t.Fatalf("unexpected addresses: %v", addresses)
+	}
+
+	var (
+		mu      sync.Mutex
+		scanned = map[string]bool{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		address := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/accounts/"), "/transactions/trc20")
+		mu.Lock()
+		scanned[address] = true
+		mu.Unlock()
+		// 第一个地址按原价支付，第二个地址金额不符
+		value := "10000000"
+		if address == addresses[1] {
+			value = "9990000"
+		}
+		fmt.Fprintf(w, `{"success":true,"data":[{"transaction_id":"tx_%s","token_info":{"symbol":"USDT","address":%q,"decimals":6},"block_timestamp":%d,"from":"TFromAddress","to":%q,"type":"Transfer","value":%q}],"meta":{}}`,
+			address, usdt, time.Now().UnixMilli(), address, value)
+	}))
+	defer server.Close()
+
+	dataSource, err := paykit.NewTronGridDataSource(paykit.TronDataSourceConfig{Network: paykit.TRON_NETWORK_NILE, Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("NewTronGridDataSource error: %v", err)
+	}
+	var fulfilled []string
+	tronClient, err := paykit.NewTron(paykit.TronConfig{
+		Network:         paykit.TRON_NETWORK_NILE,
+		DataSource:      dataSource,
+		AcceptTokens:    []paykit.TokenSymbol{paykit.USDT_TRC20},
+		AddressProvider: paykit.TronAddressPool(addresses),
+	}, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("NewTron error: %v", err)
+	}
+
+	req := &paykit.TradePreCreateReq{
+		TotalAmount: 1000,
+		Currency:    paykit.CurrencyUSD,
+		Extra:       paykit.TronExtraForTradePreCreateReq{TokenSymbol: paykit.USDT_TRC20},
+	}
+	for i, address := range addresses {
+		req.OutTradeNo = fmt.Sprintf("order_%d", i+1)
+		res, err := tronClient.TradePrecreate(ctx, req)
+		if err != nil {
+			t.Fatalf("TradePrecreate error: %v", err)
+		}
+		ex := res.Extra.(paykit.TronExtraForTradePreCreateRes)
+		if res.PayURL != address || ex.TotalAmountString != "10.00" {
+			t.Errorf("unexpected address or amount: %s, %s", res.PayURL, ex.TotalAmountString)
+		}
+	}
+	req.OutTradeNo = "order_3"
+	if _, err = tronClient.TradePrecreate(ctx, req); err == nil {
+		t.Errorf("expected addresses exhausted")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err = tronClient.Start(); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	tronClient.Stop()
+
+	if !slices.Equal(fulfilled, []string{"order_1"}) {
+		t.Errorf("unexpected fulfillments: %v", fulfilled)
+	}
+	if !scanned[addresses[0]] || !scanned[addresses[1]] {
+		t.Errorf("unexpected scanned addresses: %v", scanned)
+	}
+	// 已支付订单的地址回收复用
+	req.OutTradeNo = "order_4"
+	res, err := tronClient.TradePrecreate(ctx, req)
+	if err != nil || res.PayURL != addresses[0] {
+		t.Errorf("expected recycled address %s, got %v, %v", addresses[0], res, err)
+	}
+}
+
+// clear && go test ./test -v -run TestTronTRX
+func TestTronTRX(t *testing.T) {
+	ctx := gctx.New()
+	const (
+		address    = "T9yD14Nj9j7xAB4dbGeiX9h8unkKHxuWwb"
+		addressHex = "410000000000000000000000000000000000000000"
+		fromHex    = "41a614f803b6fd780986a42c78ec9c7f77e6ded13c"
+	)
+	var (
+		mu    sync.Mutex
+		paths []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path != "/v1/accounts/"+address+"/transactions" {
+			w.Write([]byte(`{"success":true,"data":[],"meta":{}}`))
+			return
+		}
+		tx := func(id, ret, typ string, amount int64) string {
+			return fmt.Sprintf(`{"txID":%q,"block_timestamp":%d,"ret":[{"contractRet":%q}],"raw_data":{"contract":[{"type":%q,"parameter":{"value":{"amount":%d,"owner_address":%q,"to_address":%q}}}]}}`,
+				id, time.Now().UnixMilli(), ret, typ, amount, fromHex, addressHex)
+		}
+		fmt.Fprintf(w, `{"success":true,"data":[%s,%s,%s],"meta":{}}`,
+			tx("tx_failed", "REVERT", "TransferContract", 40000000),
+			tx("tx_trigger", "SUCCESS", "TriggerSmartContract", 40000000),
+			tx("tx_trx", "SUCCESS", "TransferContract", 40000000))
+	}))
+	defer server.Close()
+
+	dataSource, err := paykit.NewTronGridDataSource(paykit.TronDataSourceConfig{Network: paykit.TRON_NETWORK_NILE, Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("NewTronGridDataSource error: %v", err)
+	}
+	var (
+		fulfilled []string
+		events    []*paykit.NotifyEvent
+	)
+	tronClient, err := paykit.NewTron(paykit.TronConfig{
+		Network:      paykit.TRON_NETWORK_NILE,
+		Address:      address,
+		DataSource:   dataSource,
+		AcceptTokens: []paykit.TokenSymbol{paykit.USDT_TRC20, paykit.TRX},
+		RateSource: paykit.TronRateFunc(func(ctx context.Context, symbol paykit.TokenSymbol) (float64, error) {
+			return 0.25, nil
+		}),
+	}, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("NewTron error: %v", err)
+	}
+	tronClient.SetEventHandler(func(e *paykit.NotifyEvent) {
+		events = append(events, e)
+	})
+
+	res, err := tronClient.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+		OutTradeNo:  "order_trx",
+		TotalAmount: 1000,
+		Currency:    paykit.CurrencyUSD,
+		Extra:       paykit.TronExtraForTradePreCreateReq{TokenSymbol: paykit.TRX},
+	})
+	if err != nil {
+		t.Fatalf("TradePrecreate error: %v", err)
+	}
+	ex := res.Extra.(paykit.TronExtraForTradePreCreateRes)
+	if ex.TotalAmountString != "40.00" || ex.USDPrice != 0.25 {
+		t.Fatalf("unexpected TRX amount: %s, price: %v", ex.TotalAmountString, ex.USDPrice)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err = tronClient.Start(); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	tronClient.Stop()
+
+	if !slices.Equal(fulfilled, []string{"order_trx"}) {
+		t.Errorf("unexpected fulfillments: %v", fulfilled)
+	}
+	if len(events) != 1 || events[0].TradeNo != "tx_trx" || events[0].Extra.(paykit.TronExtraForNotifyEvent).From != "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+	if !slices.Contains(paths, "/v1/accounts/"+address+"/transactions/trc20") {
+		t.Errorf("expected TRC20 transfers to be scanned, paths: %v", paths)
+	}
+}
+
+// clear && go test ./test -v -run TestTronPaymentTolerance
+func TestTronPaymentTolerance(t *testing.T) {
+	ctx := gctx.New()
+	const (
+		usdt        = "TXYZopYRdj2D9XRtbG411XZZ3kM5VkAeBf"
+		sharedAddr  = "T9yD14Nj9j7xAB4dbGeiX9h8unkKHxuWwb"
+		partialAddr = "TSbWuevF57ewgEDMLg1na9atTiPZPFXhoP"
+		overAddr    = "TTsE3o79AQbn37utb18bt9ZCEaFaBrNkr6"
+		splitAddr   = "TMVQGm1qAQYVdetCeGRRkTWYYrLXuHK2HC"
+		busyAddr    = "TMizoNsjVGZPrNhed3bdbTbr2nvedeHYMX"
+	)
+	// 各地址收到的转账：交易哈希 -> 金额
+	transfers := map[string][][2]string{
+		partialAddr: {{"tx_part_1", "4000000"}, {"tx_part_2", "5970000"}},
+		overAddr:    {{"tx_over", "12000000"}},
+		sharedAddr:  {{"tx_exact", "10000000"}, {"tx_near", "9980000"}, {"tx_unknown", "5000000"}},
+		splitAddr:   {{"tx_split_1", "4000000"}, {"tx_split_2", "6500000"}},
+		busyAddr:    {{"tx_busy", "9000000"}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		address := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/accounts/"), "/transactions/trc20")
+		var data []string
+		for _, v := range transfers[address] {
+			data = append(data, fmt.Sprintf(`{"transaction_id":%q,"token_info":{"symbol":"USDT","address":%q,"decimals":6},"block_timestamp":%d,"from":"TFromAddress","to":%q,"type":"Transfer","value":%q}`,
+				v[0], usdt, time.Now().UnixMilli(), address, v[1]))
+		}
+		fmt.Fprintf(w, `{"success":true,"data":[%s],"meta":{}}`, strings.Join(data, ","))
+	}))
+	defer server.Close()
+	dataSource, err := paykit.NewTronGridDataSource(paykit.TronDataSourceConfig{Network: paykit.TRON_NETWORK_NILE, Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("NewTronGridDataSource error: %v", err)
+	}
+
+	run := func(config paykit.TronConfig, orders ...string) (fulfilled []string, events []string) {
+		config.Network = paykit.TRON_NETWORK_NILE
+		config.DataSource = dataSource
+		config.AcceptTokens = []paykit.TokenSymbol{paykit.USDT_TRC20}
+		tronClient, err := paykit.NewTron(config, func(s string) {
+			fulfilled = append(fulfilled, s)
+		})
+		if err != nil {
+			t.Fatalf("NewTron error: %v", err)
+		}
+		tronClient.SetEventHandler(func(e *paykit.NotifyEvent) {
+			ex := e.Extra.(paykit.TronExtraForNotifyEvent)
+			events = append(events, fmt.Sprintf("%s %s %s %s", e.Type, e.OutTradeNo, ex.PaidAmount, strings.Join(ex.TransactionIDs, ",")))
+		})
+		for _, no := range orders {
+			_, err = tronClient.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+				OutTradeNo:  no,
+				TotalAmount: 1000,
+				Currency:    paykit.CurrencyUSD,
+				Extra:       paykit.TronExtraForTradePreCreateReq{TokenSymbol: paykit.USDT_TRC20},
+			})
+			if err != nil {
+				t.Fatalf("TradePrecreate error: %v", err)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if err = tronClient.Start(); err != nil {
+			t.Fatalf("Start error: %v", err)
+		}
+		tronClient.Stop()
+		return
+	}
+
+	// 独立地址模式：多笔转账累计，容差范围内少付视为付清，多付履约并上报
+	tolerance := paykit.TronTolerance{Amount: 0.05}
+	fulfilled, events := run(paykit.TronConfig{AddressProvider: paykit.TronAddressPool{partialAddr, overAddr}, Tolerance: tolerance}, "order_partial", "order_over")
+	if !slices.Equal(fulfilled, []string{"order_partial", "order_over"}) {
+		t.Errorf("unexpected fulfillments: %v", fulfilled)
+	}
+	if !slices.Equal(events, []string{
+		"UNDERPAID order_partial 4.00 tx_part_1",
+		"PAID order_partial 9.97 tx_part_1,tx_part_2",
+		"PAID order_over 12.00 tx_over",
+		"OVERPAID order_over 12.00 tx_over",
+	}) {
+		t.Errorf("unexpected events: %q", events)
+	}
+
+	// 唯一金额模式：金额不一致时匹配窗口内唯一的订单，容差范围内视为付清，没有待支付订单的付款上报
+	window := paykit.TronTolerance{Amount: 0.05, MatchPercent: 100}
+	fulfilled, events = run(paykit.TronConfig{Address: sharedAddr, Tolerance: window}, "order_a", "order_b")
+	if !slices.Equal(fulfilled, []string{"order_a", "order_b"}) {
+		t.Errorf("unexpected fulfillments: %v", fulfilled)
+	}
+	if !slices.Equal(events, []string{
+		"PAID order_a 10.00 tx_exact",
+		"PAID order_b 9.98 tx_near",
+		"UNMATCHED  5.00 tx_unknown",
+	}) {
+		t.Errorf("unexpected events: %q", events)
+	}
+
+	// 唯一金额模式：地址上唯一的待支付订单累计多笔付款，少付上报，付清后多付上报
+	fulfilled, events = run(paykit.TronConfig{Address: splitAddr, Tolerance: paykit.TronTolerance{MatchPercent: 100}}, "order_split")
+	if !slices.Equal(fulfilled, []string{"order_split"}) {
+		t.Errorf("unexpected fulfillments: %v", fulfilled)
+	}
+	if !slices.Equal(events, []string{
+		"UNDERPAID order_split 4.00 tx_split_1",
+		"PAID order_split 10.50 tx_split_1,tx_split_2",
+		"OVERPAID order_split 10.50 tx_split_1,tx_split_2",
+	}) {
+		t.Errorf("unexpected events: %q", events)
+	}
+
+	// 唯一金额模式：匹配窗口内有多个待支付订单时无法确定归属
+	fulfilled, events = run(paykit.TronConfig{Address: busyAddr, Tolerance: paykit.TronTolerance{MatchPercent: 100}}, "order_busy_1", "order_busy_2")
+	if len(fulfilled) != 0 || !slices.Equal(events, []string{"UNMATCHED  9.00 tx_busy"}) {
+		t.Errorf("unexpected fulfillments: %v, events: %q", fulfilled, events)
+	}
+
+	// 唯一金额模式：默认不设置匹配窗口，金额不一致的付款不计入订单
+	fulfilled, events = run(paykit.TronConfig{Address: splitAddr}, "order_split")
+	if len(fulfilled) != 0 || !slices.Equal(events, []string{"UNMATCHED  4.00 tx_split_1", "UNMATCHED  6.50 tx_split_2"}) {
+		t.Errorf("unexpected fulfillments: %v, events: %q", fulfilled, events)
+	}
+}
+
+// clear && go test ./test -v -run TestTronCursorAdvance
+func TestTronCursorAdvance(t *testing.T) {
+	ctx := gctx.New()
+	var minTimestamps []int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts, _ := strconv.ParseInt(r.URL.Query().Get("min_timestamp"), 10, 64)
+		minTimestamps = append(minTimestamps, ts)
+		w.Write([]byte(`{"success":true,"data":[],"meta":{}}`))
+	}))
+	defer server.Close()
+
+	dataSource, err := paykit.NewTronGridDataSource(paykit.TronDataSourceConfig{Network: paykit.TRON_NETWORK_NILE, Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("NewTronGridDataSource error: %v", err)
+	}
+	// 上次扫描停在 10 小时前，期间地址没有任何转账
+	stale := time.Now().Add(-10 * time.Hour).UnixMilli()
+	cursorStore := paykit.NewTronFileCursorStore(t.TempDir() + "/cursor.json")
+	if err = cursorStore.Save(ctx, &paykit.TronScanCursor{BlockTimestamp: stale}); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+	tronClient, err := paykit.NewTron(paykit.TronConfig{
+		Network:      paykit.TRON_NETWORK_NILE,
+		Address:      "TJRabPrwbZy45sbavfcjinPJC18kjpRTv8",
+		DataSource:   dataSource,
+		AcceptTokens: []paykit.TokenSymbol{paykit.USDT_TRC20},
+		CursorStore:  cursorStore,
+	}, func(string) {})
+	if err != nil {
+		t.Fatalf("NewTron error: %v", err)
+	}
+	if err = tronClient.Start(); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	tronClient.Stop()
+
+	if len(minTimestamps) != 1 || minTimestamps[0] != stale-2*time.Minute.Milliseconds() {
+		t.Errorf("unexpected min_timestamp: %v", minTimestamps)
+	}
+	// 扫描成功后游标推进至扫描结束时间减去固化延迟，下次扫描不再回溯
+	cursor, err := cursorStore.Load(ctx)
+	if err != nil || cursor == nil || cursor.BlockTimestamp < time.Now().Add(-2*time.Minute).UnixMilli() {
+		t.Errorf("cursor not advanced: %+v, %v", cursor, err)
+	}
+}
+
+// clear && go test ./test -v -run TestTronRestartCatchUp
+func TestTronRestartCatchUp(t *testing.T) {
+	ctx := gctx.New()
+	const (
+		address = "TJRabPrwbZy45sbavfcjinPJC18kjpRTv8"
+		usdt    = "TXYZopYRdj2D9XRtbG411XZZ3kM5VkAeBf"
+	)
+	var paidAt atomic.Int64 // 停机期间收到付款的区块时间
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := ""
+		if ts := paidAt.Load(); ts > 0 {
+			data = fmt.Sprintf(`{"transaction_id":"tx_downtime","token_info":{"symbol":"USDT","address":%q,"decimals":6},"block_timestamp":%d,"from":"TFromAddress","to":%q,"type":"Transfer","value":"10000000"}`,
+				usdt, ts, address)
+		}
+		fmt.Fprintf(w, `{"success":true,"data":[%s],"meta":{}}`, data)
+	}))
+	defer server.Close()
+	dataSource, err := paykit.NewTronGridDataSource(paykit.TronDataSourceConfig{Network: paykit.TRON_NETWORK_NILE, Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("NewTronGridDataSource error: %v", err)
+	}
+
+	var fulfilled []string
+	newClient := func(cursorStore paykit.TronCursorStore) *paykit.TronClient {
+		tronClient, err := paykit.NewTron(paykit.TronConfig{
+			Network:      paykit.TRON_NETWORK_NILE,
+			Address:      address,
+			DataSource:   dataSource,
+			AcceptTokens: []paykit.TokenSymbol{paykit.USDT_TRC20},
+			CursorStore:  cursorStore,
+		}, func(s string) {
+			fulfilled = append(fulfilled, s)
+		})
+		if err != nil {
+			t.Fatalf("NewTron error: %v", err)
+		}
+		return tronClient
+	}
+
+	// 下单后进程退出，停机期间用户付款
+	cursorStore := paykit.NewTronFileCursorStore(t.TempDir() + "/cursor.json")
+	tronClient := newClient(cursorStore)
+	if err = tronClient.Start(); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	tronClient.Stop()
+	_, err = tronClient.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+		OutTradeNo:  "order_restart",
+		TotalAmount: 1000,
+		Currency:    paykit.CurrencyUSD,
+		Extra:       paykit.TronExtraForTradePreCreateReq{TokenSymbol: paykit.USDT_TRC20},
+	})
+	if err != nil {
+		t.Fatalf("TradePrecreate error: %v", err)
+	}
+	// 游标中的待支付订单可由自定义存储直接读取
+	cursor, err := cursorStore.Load(ctx)
+	if err != nil || cursor == nil || len(cursor.Pending) != 1 {
+		t.Fatalf("unexpected cursor: %+v, %v", cursor, err)
+	}
+	if p := cursor.Pending[0]; p.OutTradeNo != "order_restart" || p.Address != address || p.TokenSymbol != paykit.USDT_TRC20 || p.Amount != 10_000_000 || !p.UniqueAmount || p.ExpiresAt.IsZero() {
+		t.Errorf("unexpected persisted reservation: %+v", p)
+	}
+	time.Sleep(10 * time.Millisecond)
+	paidAt.Store(time.Now().UnixMilli())
+
+	// 重启后恢复待支付订单，补扫到的付款正常履约
+	tronClient = newClient(cursorStore)
+	if err = tronClient.Start(); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	tronClient.Stop()
+	if !slices.Equal(fulfilled, []string{"order_restart"}) {
+		t.Errorf("unexpected fulfillments: %v", fulfilled)
+	}
+	cursor, err = cursorStore.Load(ctx)
+	if err != nil || cursor == nil || len(cursor.Pending) != 0 {
+		t.Errorf("unexpected cursor: %+v, %v", cursor, err)
+	}
+}
+
+// clear && go test ./test -v -run TestTronOrderLifecycle
+func TestTronOrderLifecycle(t *testing.T) {
+	ctx := gctx.New()
+	const (
+		address = "TJRabPrwbZy45sbavfcjinPJC18kjpRTv8"
+		usdt    = "TXYZopYRdj2D9XRtbG411XZZ3kM5VkAeBf"
+	)
+	base := time.Unix(time.Now().Unix(), 0)
+	var clock atomic.Int64 // 相对 base 的秒数
+	var (
+		mu        sync.Mutex
+		transfers []string
+	)
+	transfer := func(txID, value string, at time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		transfers = append(transfers, fmt.Sprintf(`{"transaction_id":%q,"token_info":{"symbol":"USDT","address":%q,"decimals":6},"block_timestamp":%d,"from":"TFromAddress","to":%q,"type":"Transfer","value":%q}`,
+			txID, usdt, base.Add(at).UnixMilli(), address, value))
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, `{"success":true,"data":[%s],"meta":{}}`, strings.Join(transfers, ","))
+	}))
+	defer server.Close()
+	dataSource, err := paykit.NewTronGridDataSource(paykit.TronDataSourceConfig{Network: paykit.TRON_NETWORK_NILE, Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("NewTronGridDataSource error: %v", err)
+	}
+
+	var (
+		fulfilled []string
+		events    []string
+	)
+	// 每次扫描使用新的客户端，待支付及过期订单通过游标存储恢复
+	cursorStore := paykit.NewTronFileCursorStore(t.TempDir() + "/cursor.json")
+	newClient := func() *paykit.TronClient {
+		tronClient, err := paykit.NewTron(paykit.TronConfig{
+			Network:      paykit.TRON_NETWORK_NILE,
+			Address:      address,
+			DataSource:   dataSource,
+			AcceptTokens: []paykit.TokenSymbol{paykit.USDT_TRC20},
+			OrderTimeout: 1800,
+			Allocator:    paykit.TronAmountStrategy{MaxDeviation: 0.001}, // 每个金额只有一个候选，过期订单的金额会被新订单复用
+			CursorStore:  cursorStore,
+			Clock: func() time.Time {
+				return base.Add(time.Duration(clock.Load()) * time.Second)
+			},
+		}, func(s string) {
+			fulfilled = append(fulfilled, s)
+		})
+		if err != nil {
+			t.Fatalf("NewTron error: %v", err)
+		}
+		tronClient.SetEventHandler(func(e *paykit.NotifyEvent) {
+			events = append(events, fmt.Sprintf("%s %s %s", e.Type, e.OutTradeNo, e.TradeNo))
+		})
+		return tronClient
+	}
+	precreate := func(no string, timeout int) paykit.TronExtraForTradePreCreateRes {
+		res, err := newClient().TradePrecreate(ctx, &paykit.TradePreCreateReq{
+			OutTradeNo:  no,
+			TotalAmount: 1000,
+			Currency:    paykit.CurrencyUSD,
+			Extra:       paykit.TronExtraForTradePreCreateReq{TokenSymbol: paykit.USDT_TRC20, OrderTimeout: timeout},
+		})
+		if err != nil {
+			t.Fatalf("TradePrecreate error: %v", err)
+		}
+		return res.Extra.(paykit.TronExtraForTradePreCreateRes)
+	}
+	refresh := func(at int64) {
+		clock.Store(at)
+		tronClient := newClient()
+		if err := tronClient.Start(); err != nil {
+			t.Fatalf("Start error: %v", err)
+		}
+		tronClient.Stop()
+	}
+
+	// 单个订单的超时时间覆盖配置，响应中返回过期时间
+	old := precreate("order_old", 600)
+	if old.ExpiresAt != base.Add(600*time.Second).Unix() || old.TotalAmountString != "10.00" {
+		t.Errorf("unexpected precreate result: %+v", old)
+	}
+
+	// 过期后(含 1 分钟宽限)释放订单并触发过期事件
+	refresh(700)
+	if !slices.Equal(events, []string{"EXPIRED order_old "}) {
+		t.Errorf("unexpected events after expiry: %q", events)
+	}
+
+	// 新订单复用过期订单的金额，按配置的超时时间过期
+	clock.Store(800)
+	reuse := precreate("order_new", 0)
+	if reuse.ExpiresAt != base.Add(2600*time.Second).Unix() || reuse.TotalAmountString != old.TotalAmountString {
+		t.Errorf("unexpected precreate result: %+v", reuse)
+	}
+
+	// 区块时间早于新订单创建时间的付款属于过期订单，作为逾期付款上报，不履约新订单
+	transfer("tx_late", "10000000", 750*time.Second)
+	refresh(810)
+	if len(fulfilled) != 0 || !slices.Equal(events[1:], []string{"LATE_PAYMENT order_old tx_late"}) {
+		t.Errorf("unexpected fulfillments: %v, events: %q", fulfilled, events)
+	}
+
+	// 新订单创建后的付款正常履约
+	transfer("tx_new", "10000000", 805*time.Second)
+	refresh(820)
+	if !slices.Equal(fulfilled, []string{"order_new"}) || !slices.Equal(events[2:], []string{"PAID order_new tx_new"}) {
+		t.Errorf("unexpected fulfillments: %v, events: %q", fulfilled, events)
+	}
+}
+
+// clear && go test ./test -v -run TestTronAmountKeys
+func TestTronAmountKeys(t *testing.T) {
+	ctx := gctx.New()
+	const (
+		address = "TJRabPrwbZy45sbavfcjinPJC18kjpRTv8"
+		usdt    = "TXYZopYRdj2D9XRtbG411XZZ3kM5VkAeBf"
+		token2  = "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t" // 精度为 2 的代币
+	)
+	var (
+		mu        sync.Mutex
+		transfers []string
+	)
+	transfer := func(txID, contract string, decimals int, value string) {
+		mu.Lock()
+		defer mu.Unlock()
+		transfers = append(transfers, fmt.Sprintf(`{"transaction_id":%q,"token_info":{"symbol":"T","address":%q,"decimals":%d},"block_timestamp":%d,"from":"TFromAddress","to":%q,"type":"Transfer","value":%q}`,
+			txID, contract, decimals, time.Now().UnixMilli(), address, value))
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, `{"success":true,"data":[%s],"meta":{}}`, strings.Join(transfers, ","))
+	}))
+	defer server.Close()
+	dataSource, err := paykit.NewTronGridDataSource(paykit.TronDataSourceConfig{Network: paykit.TRON_NETWORK_NILE, Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("NewTronGridDataSource error: %v", err)
+	}
+
+	var fulfilled []string
+	tronClient, err := paykit.NewTron(paykit.TronConfig{
+		Network:      paykit.TRON_NETWORK_NILE,
+		Address:      address,
+		DataSource:   dataSource,
+		AcceptTokens: []paykit.TokenSymbol{paykit.USDT_TRC20, paykit.USDC_TRC20},
+		Tokens:       map[paykit.TokenSymbol]paykit.TronToken{paykit.USDC_TRC20: {Contract: token2, Decimals: 2}},
+		Allocator:    paykit.TronAmountStrategy{Step: 0.001, MaxDeviation: 0.5},
+	}, func(s string) {
+		fulfilled = append(fulfilled, s)
+	})
+	if err != nil {
+		t.Fatalf("NewTron error: %v", err)
+	}
+	precreate := func(no string, symbol paykit.TokenSymbol) string {
+		res, err := tronClient.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+			OutTradeNo:  no,
+			TotalAmount: 1000,
+			Currency:    paykit.CurrencyUSD,
+			Extra:       paykit.TronExtraForTradePreCreateReq{TokenSymbol: symbol},
+		})
+		if err != nil {
+			t.Fatalf("TradePrecreate error: %v", err)
+		}
+		return res.Extra.(paykit.TronExtraForTradePreCreateRes).TotalAmountString
+	}
+
+	// 金额按代币最小单位(整数)递增，多次累加没有浮点误差
+	for i := range 300 {
+		if got, want := precreate(fmt.Sprintf("usdt_%d", i), paykit.USDT_TRC20), strings.TrimSuffix(fmt.Sprintf("10.%03d", i), "0"); got != want {
+			t.Fatalf("order %d: expected amount %s, got %s", i, want, got)
+		}
+	}
+	// 步长小于代币精度时按代币精度取整
+	for i := range 3 {
+		if got, want := precreate(fmt.Sprintf("token2_%d", i), paykit.USDC_TRC20), fmt.Sprintf("10.%02d", i); got != want {
+			t.Fatalf("order token2_%d: expected amount %s, got %s", i, want, got)
+		}
+	}
+	if no, _ := tronClient.Cacha().Get(ctx, 10.299); no.String() != "usdt_299" {
+		t.Errorf("unexpected deprecated cache snapshot: %v", no)
+	}
+
+	// 链上金额与订单的最小单位金额一致时匹配，且只释放匹配到的 key
+	transfer("tx_usdt", usdt, 6, "10123000")
+	transfer("tx_token2", token2, 2, "1001")
+	if err = tronClient.Start(); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	tronClient.Stop()
+	if !slices.Equal(fulfilled, []string{"usdt_123", "token2_1"}) {
+		t.Fatalf("unexpected fulfillments: %v", fulfilled)
+	}
+	if got := precreate("usdt_reuse", paykit.USDT_TRC20); got != "10.123" {
+		t.Errorf("expected the matched amount 10.123 to be reused, got %s", got)
+	}
+	if got := precreate("usdt_next", paykit.USDT_TRC20); got != "10.30" {
+		t.Errorf("expected other amounts to stay reserved, got %s", got)
+	}
+	if got := precreate("token2_reuse", paykit.USDC_TRC20); got != "10.01" {
+		t.Errorf("expected the matched amount 10.01 to be reused, got %s", got)
+	}
+}
+
+// clear && go test ./test -v -run TestTronConcurrentSave
+func TestTronConcurrentSave(t *testing.T) {
+	ctx := gctx.New()
+	cursorStore := paykit.NewTronFileCursorStore(t.TempDir() + "/cursor.json")
+	tronClient, err := paykit.NewTron(paykit.TronConfig{
+		Network:      paykit.TRON_NETWORK_NILE,
+		Address:      "TJRabPrwbZy45sbavfcjinPJC18kjpRTv8",
+		AcceptTokens: []paykit.TokenSymbol{paykit.USDT_TRC20},
+		CursorStore:  cursorStore,
+	}, func(string) {})
+	if err != nil {
+		t.Fatalf("NewTron error: %v", err)
+	}
+
+	// 并发下单，最后保存的游标包含全部待支付订单
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := tronClient.TradePrecreate(ctx, &paykit.TradePreCreateReq{
+				OutTradeNo:  fmt.Sprintf("order_%d", i),
+				TotalAmount: 1000,
+				Currency:    paykit.CurrencyUSD,
+				Extra:       paykit.TronExtraForTradePreCreateReq{TokenSymbol: paykit.USDT_TRC20},
+			})
+			if err != nil {
+				t.Errorf("TradePrecreate error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	cursor, err := cursorStore.Load(ctx)
+	if err != nil || cursor == nil || len(cursor.Pending) != 50 {
+		t.Fatalf("expected 50 persisted pending orders, got %+v, %v", cursor, err)
+	}
+}
@@ -3,13 +3,15 @@ package paykit
 import (
 	"context"
 	"fmt"
+	"maps"
+	"math"
 	"net/http"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/gogf/gf/v2/net/gclient"
 	"github.com/gogf/gf/v2/os/gcache"
 	"github.com/gogf/gf/v2/os/gcron"
 	"github.com/gogf/gf/v2/os/gctx"
@@ -19,12 +21,16 @@ import (
 var _ PaymentInterface = (*TronClient)(nil)
 
 const (
-	_MAINNET           = "https://api.trongrid.io/v1/accounts/{address}/transactions/trc20"
-	_NILE_TESTNET      = "https://nile.trongrid.io/v1/accounts/{address}/transactions/trc20"
-	_TRONGRID_JOB_NAME = "Trongrid transactions"
-	_TRON_LOG_TAG      = "[Tron]"
-	_TRON_LOG_PATH     = "./.log/tron"
-	_TRON_LOG_LEVEL    = "error"
+	_TRON_JOB_NAME  = "Tron transactions"
+	_TRON_LOG_TAG   = "[Tron]"
+	_TRON_LOG_PATH  = "./.log/tron"
+	_TRON_LOG_LEVEL = "error"
+
+	_TRON_ORDER_TIMEOUT       = 30 * time.Minute // 默认订单超时时间
+	_TRON_EXPIRE_GRACE        = time.Minute      // 已确认交易的区块时间与查询到的时间约有 1 分钟延迟，过期订单延后释放
+	_TRON_LATE_PAYMENT_WINDOW = 24 * time.Hour   // 过期订单保留时间，期间收到的付款作为逾期付款上报，也是停机后补扫的最长时间
+	_TRON_SCAN_OVERLAP        = 2 * time.Minute  // 每次扫描与上次游标重叠的时间，防止接口索引延迟漏单，重叠部分按交易 ID 去重
+	_TRON_CONFIRMATION_LAG    = time.Minute      // 交易固化的延迟，扫描成功后游标推进至扫描结束时间减去该延迟，避免空闲地址的扫描范围不断扩大
 )
 
 type TokenSymbol string
@@ -32,65 +38,225 @@ type TokenSymbol string
 const (
 	USDT_TRC20 TokenSymbol = "USDT"
 	USDC_TRC20 TokenSymbol = "USDC"
+	TRX        TokenSymbol = "TRX" // 原生 TRX，最小单位为 sun，按 TronConfig.RateSource 的价格计价
 )
 
+const _TRX_DECIMALS = 6 // 1 TRX = 1,000,000 sun
+
+// TronToken TRC20 代币合约信息，转账的合约地址及精度均需一致，防止同名的仿冒代币
+type TronToken struct {
+	Contract string `json:"contract"` // 合约地址(Base58)
+	Decimals int    `json:"decimals"` // 精度
+}
+
+// 各网络的默认代币合约
+var _TRON_DEFAULT_TOKENS = map[TronNetwork]map[TokenSymbol]TronToken{
+	TRON_NETWORK_MAINNET: {
+		USDT_TRC20: {Contract: "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", Decimals: 6},
+		USDC_TRC20: {Contract: "TEkxiTehnzSmSe2XqrBj4w32RUN966rdz8", Decimals: 6},
+	},
+	TRON_NETWORK_NILE: {
+		USDT_TRC20: {Contract: "TXYZopYRdj2D9XRtbG411XZZ3kM5VkAeBf", Decimals: 6},
+	},
+}
+
 type TronConfig struct {
 	PaymentKey   any
-	Address      string        // 收款钱包地址
-	APIKey       string        // Trongrid API密钥，用于监听网络交易。如果为空，则使用测试网
-	AcceptTokens []TokenSymbol // 可接受的代币类型，如 USDT, USDC 等
-	OrderTimeout int           // 订单超时时间(秒)
-}
-type trongridRes struct {
-	Success bool   `json:"success"`
-	Data    []data `json:"data"`
-}
-
-type data struct {
-	TransactionID string `json:"transaction_id"`
-	TokenInfo     struct {
-		Symbol   string `json:"symbol"`
-		Address  string `json:"address"`
-		Decimals int    `json:"decimals"`
-		Name     string `json:"name"`
-	} `json:"token_info"`
-	BlockTimestamp int64  `json:"block_timestamp"`
-	From           string `json:"from"`
-	To             string `json:"to"`
-	Type           string `json:"type"`
-	Value          string `json:"value"`
+	Network      TronNetwork               // 网络，必填：mainnet, nile, shasta
+	Address      string                    // 收款钱包地址，唯一金额模式下所有订单共用
+	APIKey       string                    // TronGrid API 密钥，使用默认数据源时以 TRON-PRO-API-KEY 请求头发送
+	DataSource   TronDataSource            // 链上数据源，为空时使用 TronGrid，可选 NewTronScanDataSource、NewTronNodeDataSource
+	AcceptTokens []TokenSymbol             // 可接受的代币类型，如 USDT, USDC 等
+	Tokens       map[TokenSymbol]TronToken // 代币合约，覆盖内置默认值。内置：主网 USDT、USDC，Nile 测试网 USDT。TRX 无需配置
+	RateSource   TronRateSource            // TRX 的美元价格来源，为空时使用 Binance 行情。USDT、USDC 按 1 美元计价
+	Tolerance    TronTolerance             // 付款金额容差及唯一金额模式的匹配窗口，默认必须付清
+	OrderTimeout int                       // 订单超时时间(秒)
+	Allocator    TronAmountAllocator       // 唯一金额分配策略，为空时使用 TronAmountStrategy 默认值
+	CursorStore  TronCursorStore           // 扫描游标及待支付订单存储，为空时保存在内存中，重启后待支付订单丢失，仅扫描最近的交易
+
+	AddressProvider TronAddressProvider // 收款地址提供者，设置后每个订单分配独立的收款地址并按原价收款，不再使用 Address 及 Allocator
+
+	Clock func() time.Time // 当前时间，为空时使用 time.Now，用于测试订单过期等时间相关的逻辑
 }
 
 type TronExtraForTradePreCreateReq struct {
-	TokenSymbol TokenSymbol `json:"tokenSymbol"` // 当前订单指定的代币符号(USDT, USDC等)
+	TokenSymbol  TokenSymbol `json:"tokenSymbol"`  // 当前订单指定的代币符号(USDT, USDC等)
+	OrderTimeout int         `json:"orderTimeout"` // 订单超时时间(秒)，覆盖 TronConfig.OrderTimeout
 }
 type TronExtraForTradePreCreateRes struct {
 	TotalAmountString string      `json:"totalAmountString"` // 加密货币价格，直接返回 2 位小数的支付金额，并提示用户严格按照该价格支付，否则无法履约订单
+	Address           string      `json:"address"`           // 当前订单的收款地址，同 PayURL
 	TokenSymbol       TokenSymbol `json:"tokenSymbol"`       // 当前订单指定的代币符号(USDT, USDC等)
+	USDPrice          float64     `json:"usdPrice"`          // 下单时 1 个代币对应的美元价格，稳定币为 1
+	ExpiresAt         int64       `json:"expiresAt"`         // 订单过期时间(秒级时间戳)，过期后的付款不会自动履约
+}
+
+type TronExtraForNotifyEvent struct {
+	Address        string      `json:"address"`        // 收款地址
+	TokenSymbol    TokenSymbol `json:"tokenSymbol"`    // 代币符号
+	Amount         string      `json:"amount"`         // 订单应付金额
+	ExpiresAt      int64       `json:"expiresAt"`      // 订单过期时间(秒级时间戳)
+	TransactionID  string      `json:"transactionID"`  // 交易哈希，订单过期事件为空
+	From           string      `json:"from"`           // 付款地址
+	PaidAmount     string      `json:"paidAmount"`     // 累计实付金额，无法匹配订单的付款为该笔转账金额
+	TransactionIDs []string    `json:"transactionIDs"` // 订单已收到的全部付款交易哈希，用于人工处理少付、多付
+	BlockTimestamp int64       `json:"blockTimestamp"` // 交易区块时间(毫秒级时间戳)
+}
+
+// tronOrderKey 待支付订单的 key，唯一金额模式下为收款地址、代币及金额(代币最小单位)，独立地址模式下金额为 0
+type tronOrderKey struct {
+	Address     string
+	TokenSymbol TokenSymbol
+	Amount      int64
+}
+
+// tronOrder 待支付订单
+type tronOrder struct {
+	OutTradeNo  string
+	Address     string // 收款地址
+	TokenSymbol TokenSymbol
+	Amount      int64 // 应付金额，代币最小单位
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+
+	Paid           int64    // 累计实付金额，代币最小单位
+	TransactionIDs []string // 付款交易哈希
+}
+
+func (o *tronOrder) add(amount int64, transactionID string) {
+	o.Paid += amount
+	o.TransactionIDs = append(o.TransactionIDs, transactionID)
+}
+
+// snapshot 订单副本，在锁外读取
+func (o *tronOrder) snapshot() tronOrder {
+	s := *o
+	s.TransactionIDs = slices.Clone(o.TransactionIDs)
+	return s
 }
 
 type TronClient struct {
 	config          TronConfig
+	tokens          map[TokenSymbol]TronToken
 	logger          *glog.Logger
-	httpClient      *gclient.Client
+	dataSource      TronDataSource
 	cron            *gcron.Cron
-	cache           *gcache.Cache
+	allocator       TronAmountAllocator
+	reservations    *tronReservations
+	cursorStore     TronCursorStore
+	cursorMu        sync.Mutex // 保护 cursor 的修改及保存，保存时在锁内读取订单占用快照，cursor 仅在 refresh 中修改
+	cursor          *TronScanCursor
+	rateSource      TronRateSource
 	fulfillCheckout func(string)
+	eventHandler    func(*NotifyEvent)
 }
 
-func NewTron(config TronConfig, fulfillCheckout func(string)) (*TronClient, error) {
-	// 设置日志
+// now 当前时间
+func (t *TronClient) now() time.Time {
+	if t.config.Clock != nil {
+		return t.config.Clock()
+	}
+	return time.Now()
+}
+
+// newTronLogger 设置日志
+func newTronLogger() *glog.Logger {
 	l := glog.New()
 	_ = l.SetPath(_TRON_LOG_PATH)
 	_ = l.SetLevelStr(_TRON_LOG_LEVEL)
 	l.SetPrefix(_TRON_LOG_TAG)
 	l.SetStack(false)
+	return l
+}
+
+func NewTron(config TronConfig, fulfillCheckout func(string)) (*TronClient, error) {
+	l := newTronLogger()
+
+	if config.Network == "" {
+		return nil, fmt.Errorf("tron network not configured")
+	}
+	dataSource := config.DataSource
+	if dataSource == nil {
+		var err error
+		dataSource, err = NewTronGridDataSource(TronDataSourceConfig{Network: config.Network, APIKey: config.APIKey})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	defaults := _TRON_DEFAULT_TOKENS[config.Network]
+	tokens := make(map[TokenSymbol]TronToken, len(config.AcceptTokens))
+	for _, symbol := range config.AcceptTokens {
+		if symbol == TRX {
+			tokens[symbol] = TronToken{Decimals: _TRX_DECIMALS}
+			continue
+		}
+		token, ok := config.Tokens[symbol]
+		if !ok {
+			token, ok = defaults[symbol]
+		}
+		if !ok || token.Contract == "" || token.Decimals < 2 {
+			return nil, fmt.Errorf("token %s contract not configured", symbol)
+		}
+		tokens[symbol] = token
+	}
+	if w, ok := dataSource.(tronContractWatcher); ok {
+		contracts := make([]string, 0, len(tokens))
+		for _, token := range tokens {
+			if token.Contract != "" {
+				contracts = append(contracts, token.Contract)
+			}
+		}
+		w.watchContracts(contracts)
+	}
+
+	if config.AddressProvider != nil {
+		addresses := config.AddressProvider.Addresses()
+		if len(addresses) == 0 {
+			return nil, fmt.Errorf("tron address provider has no addresses")
+		}
+		for _, address := range addresses {
+			if !tronValidAddress(address) {
+				return nil, fmt.Errorf("invalid tron address: %s", address)
+			}
+		}
+	}
+
+	allocator := config.Allocator
+	if allocator == nil {
+		allocator = TronAmountStrategy{}
+	}
+	cursorStore := config.CursorStore
+	if cursorStore == nil {
+		cursorStore = &tronMemoryCursorStore{}
+	}
+	rateSource := config.RateSource
+	if rateSource == nil {
+		rateSource = NewTronBinanceRateSource()
+	}
+
+	// 恢复游标及订单占用
+	cursor, err := cursorStore.Load(gctx.New())
+	if err != nil {
+		return nil, fmt.Errorf("load tron scan cursor error: %w", err)
+	}
+	if cursor == nil {
+		cursor = &TronScanCursor{}
+	}
+	reservations := newTronReservations()
+	reservations.restore(cursor.Pending, cursor.Expired)
+	cursor.Pending, cursor.Expired = nil, nil
 
 	return &TronClient{
 		config:          config,
-		httpClient:      gclient.New(),
+		tokens:          tokens,
+		dataSource:      dataSource,
 		cron:            gcron.New(),
-		cache:           gcache.New(),
+		allocator:       allocator,
+		reservations:    reservations,
+		cursorStore:     cursorStore,
+		cursor:          cursor,
+		rateSource:      rateSource,
 		fulfillCheckout: fulfillCheckout,
 		logger:          l,
 	}, nil
@@ -103,112 +269,374 @@ func (t *TronClient) TradePrecreate(ctx context.Context, req *TradePreCreateReq)
 		return nil, fmt.Errorf("token %s not supported", ex.TokenSymbol)
 	}
 
-	// 汇率转换，暂时用法币汇率 USD 代替。USD 返回值已经保留2位小数
-	ta, err := ERInstance.ConvertToStandard(ctx, req.TotalAmount, req.Currency, CurrencyUSD)
+	// 汇率转换，暂时用法币汇率 USD 代替，单位：美分
+	cents, err := ERInstance.Convert(ctx, req.TotalAmount, req.Currency, CurrencyUSD)
 	if err != nil {
 		return nil, err
 	}
+	if cents <= 0 {
+		cents = 1
+	}
 
-	if ta <= 0 {
-		ta = 0.01
+	timeout := _TRON_ORDER_TIMEOUT
+	if ex.OrderTimeout > 0 {
+		timeout = time.Duration(ex.OrderTimeout) * time.Second
+	} else if t.config.OrderTimeout > 0 {
+		timeout = time.Duration(t.config.OrderTimeout) * time.Second
+	}
+	now := t.now()
+	order := &tronOrder{
+		OutTradeNo:  req.OutTradeNo,
+		TokenSymbol: ex.TokenSymbol,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(timeout),
+	}
+
+	// 价格以代币最小单位(整数)表示，如 USDT 精度为 6：1.23 USDT = 1,230,000 最小单位
+	token := t.tokens[ex.TokenSymbol]
+	price := 1.0
+	amount := cents * tronPow10(token.Decimals-2)
+	if ex.TokenSymbol == TRX {
+		// 按实时价格换算，向上取整到 0.01 TRX
+		if price, err = t.rateSource.USDPrice(ctx, TRX); err != nil {
+			return nil, fmt.Errorf("get TRX price error: %w", err)
+		}
+		amount = int64(math.Ceil(float64(cents)/price-1e-9)) * tronPow10(token.Decimals-2)
 	}
 
-	// 原理类似于：https://github.com/assimon/epusdt
-	// 缓存：key=价格 value=订单号
-	for i := range 100 {
-		if i == 100 {
-			err = fmt.Errorf("amount %.2f failed to generate key", ta)
-			return
+	if t.config.AddressProvider != nil {
+		// 独立地址模式：按原价收款，同一代币的待支付订单收款地址唯一
+		order.Amount = amount
+		addresses := t.config.AddressProvider.Addresses()
+		keys := make([]tronOrderKey, 0, len(addresses))
+		for _, address := range addresses {
+			keys = append(keys, tronOrderKey{Address: address, TokenSymbol: ex.TokenSymbol})
 		}
-		if exist, _ := t.cache.Contains(ctx, ta); !exist {
-			t.cache.Set(ctx, ta, req.OutTradeNo, time.Minute*30) // TODO 缓存时间：30分钟
-			break
+		if !t.reservations.reserve(keys, order) {
+			return nil, fmt.Errorf("tron addresses exhausted, all %d addresses are reserved for %s", len(keys), ex.TokenSymbol)
+		}
+	} else {
+		// 唯一金额模式，原理类似于：https://github.com/assimon/epusdt
+		// 同一代币的待支付订单金额唯一，过期订单由 refresh 释放并触发过期事件
+		candidates := t.allocator.Candidates(amount, token.Decimals)
+		keys := make([]tronOrderKey, 0, len(candidates))
+		for _, v := range candidates {
+			keys = append(keys, tronOrderKey{Address: t.config.Address, TokenSymbol: ex.TokenSymbol, Amount: v})
+		}
+		if !t.reservations.reserve(keys, order) {
+			return nil, fmt.Errorf("amount %s %s exhausted, all %d candidate amounts are reserved", tronFormatAmount(amount, token.Decimals), ex.TokenSymbol, len(candidates))
 		}
-		ta += 0.01
 	}
+	t.saveCursor(ctx)
 
 	return &TradePreCreateRes{
 		OutTradeNo: req.OutTradeNo,
-		PayURL:     t.config.Address,
+		PayURL:     order.Address,
 		Extra: TronExtraForTradePreCreateRes{
-			TotalAmountString: fmt.Sprintf("%.2f", ta),
+			TotalAmountString: tronFormatAmount(order.Amount, token.Decimals),
+			Address:           order.Address,
 			TokenSymbol:       ex.TokenSymbol,
+			USDPrice:          price,
+			ExpiresAt:         order.ExpiresAt.Unix(),
 		},
 	}, nil
 
 }
+
+// Release 释放订单占用的支付金额或收款地址，如用户取消订单，返回订单是否存在
+func (t *TronClient) Release(outTradeNo string) bool {
+	if !t.reservations.release(outTradeNo) {
+		return false
+	}
+	t.saveCursor(gctx.New())
+	return true
+}
+
+// Cacha 返回待支付订单的快照，key 为应付金额(float64，标准单位)，独立地址模式下为收款地址，value 为订单号
+//
+// Deprecated: 待支付订单已改为按代币最小单位(整数)保存，不再使用 gcache。修改返回的缓存不会影响订单占用，
+// 释放订单请使用 Release，使用完毕后可调用 Close 释放缓存
 func (t *TronClient) Cacha() *gcache.Cache {
-	return t.cache
+	ctx := gctx.New()
+	cache := gcache.New()
+	pending, _ := t.reservations.snapshot()
+	for _, v := range pending {
+		var key any = v.Address
+		if v.UniqueAmount {
+			key = float64(v.Amount) / math.Pow10(t.tokens[v.TokenSymbol].Decimals)
+		}
+		_ = cache.Set(ctx, key, v.OutTradeNo, 0)
+	}
+	return cache
 }
 
 func (t *TronClient) Start() (err error) {
 	ctx := gctx.New()
-	_, err = t.cron.Add(ctx, "*/5 * * * * *", t.refresh, _TRONGRID_JOB_NAME) // 每 5 秒执行一次
+	// 单例模式，上次扫描未结束时跳过本次执行，避免重复处理交易
+	_, err = t.cron.AddSingleton(ctx, "*/5 * * * * *", t.refresh, _TRON_JOB_NAME) // 每 5 秒执行一次
 	t.refresh(ctx)
 	return
 }
 func (t *TronClient) Stop() {
-
+	t.cron.Stop()
 }
+
+// refresh 从游标处扫描各收款地址的转入交易，全部地址扫描完成后推进游标
+//
+// 停机或接口异常期间的交易在恢复后补扫，补扫范围最长为 _TRON_LATE_PAYMENT_WINDOW
 func (t *TronClient) refresh(ctx context.Context) {
+	defer t.releaseExpired(ctx)
+
 	var (
-		url    string
-		res    *trongridRes
-		params = map[string]any{
-			"only_confirmed": true,
-			"only_to":        true,
-			"min_timestamp":  time.Now().UnixMilli() - 10*1000, // 拉取 10秒 之前的数据
-			"max_timestamp":  time.Now().UnixMilli(),
-		}
+		now     = t.now()
+		minTime = now.Add(-_TRON_SCAN_OVERLAP).UnixMilli()
+		maxTime = now.UnixMilli()
+		latest  = t.cursor.BlockTimestamp
 	)
-	if t.config.APIKey == "" {
-		url = strings.ReplaceAll(_NILE_TESTNET, "{address}", t.config.Address)
-	} else {
-		url = strings.ReplaceAll(_MAINNET, "{address}", t.config.Address)
+	if t.cursor.BlockTimestamp > 0 {
+		minTime = max(t.cursor.BlockTimestamp-_TRON_SCAN_OVERLAP.Milliseconds(), now.Add(-_TRON_LATE_PAYMENT_WINDOW).UnixMilli())
 	}
 
-	err := t.httpClient.GetVar(ctx, url, params).Scan(&res)
-	if err != nil {
-		t.logger.Error(ctx, "refresh tron transactions error:", err.Error())
+	addresses := []string{t.config.Address}
+	if t.config.AddressProvider != nil {
+		// 仅扫描分配给待支付及已过期订单的地址，没有订单时此前的交易不属于任何订单
+		addresses = t.reservations.addresses()
+	}
+	ts, ok := t.scanAddresses(ctx, addresses, minTime, maxTime)
+	if !ok {
 		return
 	}
-	t.logger.Debug(ctx, "refresh tron transactions, data lenght: ", len(res.Data))
-	for _, v := range res.Data {
-		// api 接口返回的金额为 TRC20 代币的最小单位
-		amount, err := strconv.ParseFloat(v.Value, 64)
-		if err != nil {
-			t.logger.Error(ctx, err.Error())
-			continue
+	latest = max(latest, ts)
+
+	// 全部地址扫描成功，扫描区间内已固化的交易均已处理
+	latest = max(latest, maxTime-_TRON_CONFIRMATION_LAG.Milliseconds())
+	t.cursorMu.Lock()
+	t.cursor.BlockTimestamp = latest
+	t.cursor.prune(latest - _TRON_SCAN_OVERLAP.Milliseconds())
+	t.cursorMu.Unlock()
+	t.saveCursor(ctx)
+}
+
+// saveCursor 保存游标及当前的订单占用，下单、释放订单及每页扫描完成后调用
+//
+// 快照与保存在同一把锁内完成，避免并发保存时较旧的快照覆盖较新的快照
+func (t *TronClient) saveCursor(ctx context.Context) {
+	t.cursorMu.Lock()
+	defer t.cursorMu.Unlock()
+	pending, expired := t.reservations.snapshot()
+	cursor := &TronScanCursor{
+		BlockTimestamp: t.cursor.BlockTimestamp,
+		Seen:           maps.Clone(t.cursor.Seen),
+		Pending:        pending,
+		Expired:        expired,
+	}
+	if err := t.cursorStore.Save(ctx, cursor); err != nil {
+		t.logger.Error(ctx, "save tron scan cursor error:", err.Error())
+	}
+}
+
+// tronTransferFetcher 分页查询转入交易，对应 TronDataSource 的 TRC20Transfers 或 TRXTransfers
+type tronTransferFetcher func(ctx context.Context, address string, minTimestamp, maxTimestamp int64, cursor string) ([]*TronTransfer, string, error)
+
+// transferTypes 根据可接受的代币选择需要查询的转账类型
+func (t *TronClient) transferTypes() (trc20, trx bool) {
+	trc20 = slices.ContainsFunc(t.config.AcceptTokens, func(s TokenSymbol) bool { return s != TRX })
+	trx = slices.Contains(t.config.AcceptTokens, TRX)
+	return
+}
+
+// fetchers 需要查询的转账类型对应的分页查询方法
+func (t *TronClient) fetchers() []tronTransferFetcher {
+	var fetchers []tronTransferFetcher
+	trc20, trx := t.transferTypes()
+	if trc20 {
+		fetchers = append(fetchers, t.dataSource.TRC20Transfers)
+	}
+	if trx {
+		fetchers = append(fetchers, t.dataSource.TRXTransfers)
+	}
+	return fetchers
+}
+
+// scanAddresses 扫描全部收款地址，返回最新的区块时间及是否全部扫描成功
+//
+// 逐个区块扫描的数据源(java-tron)每页区块只拉取一次并同时匹配全部地址及转账类型，其他数据源按地址逐个查询
+func (t *TronClient) scanAddresses(ctx context.Context, addresses []string, minTime, maxTime int64) (latest int64, ok bool) {
+	if len(addresses) == 0 {
+		return 0, true
+	}
+	if s, isBlock := t.dataSource.(tronBlockScanner); isBlock {
+		trc20, trx := t.transferTypes()
+		fetch := func(ctx context.Context, _ string, minTimestamp, maxTimestamp int64, cursor string) ([]*TronTransfer, string, error) {
+			return s.blockTransfers(ctx, addresses, trc20, trx, minTimestamp, maxTimestamp, cursor)
+		}
+		return t.scan(ctx, fetch, strings.Join(addresses, ","), minTime, maxTime)
+	}
+	for _, address := range addresses {
+		for _, fetch := range t.fetchers() {
+			ts, ok := t.scan(ctx, fetch, address, minTime, maxTime)
+			if !ok {
+				return latest, false
+			}
+			latest = max(latest, ts)
 		}
-		// 目前只支持 USDT_TRC20，USDC_TRC20
-		// TRC20 代币的最小单位值需要除以100万才能得到标准单位： 1 USDT = 1,000,000 最小单位
-		// 精度为 0.01，和上面 TradePrecreate 时 最终的价格（key）保持一致
-		key := amount / 1e6
-		t.logger.Debugf(ctx, "refresh tron transactions, amount(key): %.2f", key)
+	}
+	return latest, true
+}
 
-		va, err := t.cache.Get(ctx, key)
+// scan 按页拉取地址的转入交易直至没有更多数据，每页处理完成后保存已处理的交易 ID，返回最新的区块时间及是否全部扫描成功
+func (t *TronClient) scan(ctx context.Context, fetch tronTransferFetcher, address string, minTime, maxTime int64) (latest int64, ok bool) {
+	var next string
+	for {
+		transfers, cursor, err := fetch(ctx, address, minTime, maxTime, next)
 		if err != nil {
-			t.logger.Error(ctx, err.Error())
-			continue
+			t.logger.Errorf(ctx, "refresh tron transactions error, address: %s, %s", address, err.Error())
+			return latest, false
 		}
-		if va == nil {
-			t.logger.Debugf(ctx, "refresh tron transactions, amount(key): %.2f, invalid order, continue", amount)
-			continue
+		t.logger.Debug(ctx, "refresh tron transactions, data lenght: ", len(transfers))
+		for _, v := range transfers {
+			latest = max(latest, v.BlockTimestamp)
+			if t.cursor.seen(v.TransactionID) {
+				continue
+			}
+			t.handleTransfer(ctx, v)
+			t.cursorMu.Lock()
+			t.cursor.mark(v.TransactionID, v.BlockTimestamp)
+			t.cursorMu.Unlock()
 		}
-		var outTradeNo string
-		err = va.Scan(&outTradeNo)
-		if err != nil {
-			t.logger.Error(ctx, err.Error())
-			continue
+		if cursor == "" {
+			return latest, true
 		}
-		t.logger.Debugf(ctx, "refresh tron transactions, amount(key): %.2f, outTradeNo: %s", amount, outTradeNo)
+		t.saveCursor(ctx)
+		next = cursor
+	}
+}
+
+// handleTransfer 处理一笔转入交易
+func (t *TronClient) handleTransfer(ctx context.Context, v *TronTransfer) {
+	// 校验合约地址及精度，仅处理可接受代币的转入交易
+	symbol, ok := t.tokenSymbol(v.Contract)
+	if !ok || (t.config.AddressProvider == nil && v.To != t.config.Address) {
+		t.logger.Debugf(ctx, "refresh tron transactions, ignore transaction: %s, contract: %s, to: %s", v.TransactionID, v.Contract, v.To)
+		return
+	}
+	token := t.tokens[symbol]
+	if v.Decimals != token.Decimals {
+		t.logger.Errorf(ctx, "refresh tron transactions, transaction: %s, token %s decimals mismatch: %d", v.TransactionID, symbol, v.Decimals)
+		return
+	}
+
+	// 接口返回的金额为 TRC20 代币的最小单位，和 TradePrecreate 时的价格（key）保持一致
+	amount, err := strconv.ParseInt(v.Value, 10, 64)
+	if err != nil {
+		t.logger.Error(ctx, err.Error())
+		return
+	}
+	key := tronOrderKey{Address: v.To, TokenSymbol: symbol, Amount: amount}
+	if t.config.AddressProvider != nil {
+		key.Amount = 0
+	}
+	t.logger.Debugf(ctx, "refresh tron transactions, address: %s, amount: %s %s", v.To, tronFormatAmount(amount, token.Decimals), symbol)
 
-		// 释放 key
-		t.cache.Remove(ctx, v.Value)
+	t.matchOrder(ctx, key, amount, v)
+}
+
+// matchOrder 匹配转账对应的订单：区块时间在订单有效期内且付清的订单履约，不足时上报少付，多付时履约并上报多付，
+// 过期后的付款作为逾期付款上报，无法匹配订单的付款同样上报，便于人工处理
+func (t *TronClient) matchOrder(ctx context.Context, key tronOrderKey, amount int64, v *TronTransfer) {
+	decimals := t.tokens[key.TokenSymbol].Decimals
+	order, result := t.reservations.match(key, time.UnixMilli(v.BlockTimestamp), amount, v.TransactionID, t.config.Tolerance, decimals)
+	if result == tronMatchNone {
+		t.logger.Infof(ctx, "refresh tron transactions, unmatched payment, address: %s, amount: %s %s, transaction: %s", key.Address, tronFormatAmount(amount, decimals), key.TokenSymbol, v.TransactionID)
+		t.emitEvent(NOTIFY_EVENT_UNMATCHED, "", v.TransactionID, TronExtraForNotifyEvent{
+			Address:        key.Address,
+			TokenSymbol:    key.TokenSymbol,
+			TransactionID:  v.TransactionID,
+			From:           v.From,
+			PaidAmount:     tronFormatAmount(amount, decimals),
+			TransactionIDs: []string{v.TransactionID},
+			BlockTimestamp: v.BlockTimestamp,
+		})
+		return
+	}
+	t.logger.Debugf(ctx, "refresh tron transactions, address: %s, amount: %d, outTradeNo: %s", key.Address, amount, order.OutTradeNo)
+
+	ex := t.eventExtra(&order)
+	ex.TransactionID = v.TransactionID
+	ex.From = v.From
+	ex.BlockTimestamp = v.BlockTimestamp
+	switch result {
+	case tronMatchLate:
+		t.logger.Infof(ctx, "refresh tron transactions, late payment, outTradeNo: %s, transaction: %s", order.OutTradeNo, v.TransactionID)
+		t.emitEvent(NOTIFY_EVENT_LATE_PAYMENT, order.OutTradeNo, v.TransactionID, ex)
+	case tronMatchPartial:
+		t.logger.Infof(ctx, "refresh tron transactions, underpaid, outTradeNo: %s, paid: %s/%s", order.OutTradeNo, ex.PaidAmount, ex.Amount)
+		t.emitEvent(NOTIFY_EVENT_UNDERPAID, order.OutTradeNo, v.TransactionID, ex)
+	case tronMatchPaid:
 		// 履约订单
-		t.fulfillCheckout(outTradeNo)
+		t.fulfillCheckout(order.OutTradeNo)
+		t.emitEvent(NOTIFY_EVENT_PAID, order.OutTradeNo, v.TransactionID, ex)
+		if order.Paid > order.Amount {
+			t.logger.Infof(ctx, "refresh tron transactions, overpaid, outTradeNo: %s, paid: %s/%s", order.OutTradeNo, ex.PaidAmount, ex.Amount)
+			t.emitEvent(NOTIFY_EVENT_OVERPAID, order.OutTradeNo, v.TransactionID, ex)
+		}
 	}
-	return
+}
+
+// releaseExpired 释放过期订单的金额并触发过期事件，过期订单保留一段时间用于识别逾期付款，有订单过期时保存游标
+func (t *TronClient) releaseExpired(ctx context.Context) {
+	orders := t.reservations.releaseExpired(t.now())
+	if len(orders) > 0 {
+		t.saveCursor(ctx)
+	}
+	for _, order := range orders {
+		t.logger.Debugf(ctx, "refresh tron transactions, order expired, outTradeNo: %s", order.OutTradeNo)
+		t.emitEvent(NOTIFY_EVENT_EXPIRED, order.OutTradeNo, "", t.eventExtra(&order))
+	}
+}
+
+func (t *TronClient) eventExtra(order *tronOrder) TronExtraForNotifyEvent {
+	decimals := t.tokens[order.TokenSymbol].Decimals
+	ex := TronExtraForNotifyEvent{
+		Address:        order.Address,
+		TokenSymbol:    order.TokenSymbol,
+		Amount:         tronFormatAmount(order.Amount, decimals),
+		ExpiresAt:      order.ExpiresAt.Unix(),
+		TransactionIDs: order.TransactionIDs,
+	}
+	if order.Paid > 0 {
+		ex.PaidAmount = tronFormatAmount(order.Paid, decimals)
+	}
+	return ex
+}
+
+// SetEventHandler 设置事件处理函数，支付成功、少付、多付、订单过期、逾期付款等事件会归一化后回调
+func (t *TronClient) SetEventHandler(handler func(*NotifyEvent)) {
+	t.eventHandler = handler
+}
+
+func (t *TronClient) emitEvent(eventType NotifyEventType, outTradeNo, tradeNo string, ex TronExtraForNotifyEvent) {
+	if t.eventHandler == nil {
+		return
+	}
+	t.eventHandler(&NotifyEvent{
+		PaymentKey:  t.config.PaymentKey,
+		PaymentType: PAYMENT_TYPE_TRON,
+		Type:        eventType,
+		OutTradeNo:  outTradeNo,
+		TradeNo:     tradeNo,
+		Extra:       ex,
+	})
+}
+
+// tokenSymbol 根据合约地址获取可接受的代币符号
+func (t *TronClient) tokenSymbol(contract string) (TokenSymbol, bool) {
+	for symbol, token := range t.tokens {
+		if token.Contract == contract {
+			return symbol, true
+		}
+	}
+	return "", false
 }
 func (t *TronClient) Notify(http.ResponseWriter, *http.Request) {
 
@@ -0,0 +1,147 @@
+package paykit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"golang.org/x/crypto/sha3"
+)
+
+// TronAddressProvider 收款地址提供者
+//
+// 设置 TronConfig.AddressProvider 后每个订单分配独立的收款地址，按原价收款并通过收款地址匹配订单，
+// 订单支付或过期后地址回收复用，同一地址同一代币同时只分配给一个待支付订单
+type TronAddressProvider interface {
+	Addresses() []string // 全部收款地址(Base58)，按优先顺序分配
+}
+
+// TronAddressPool 固定的收款地址池
+type TronAddressPool []string
+
+func (p TronAddressPool) Addresses() []string {
+	return p
+}
+
+// TronXPubAddressProvider 由扩展公钥(xpub)派生收款地址，服务端不保存私钥
+type TronXPubAddressProvider struct {
+	addresses []string
+}
+
+// NewTronXPubAddressProvider 按 BIP44 派生 count 个收款地址
+//
+// xpub 为账户扩展公钥 m/44'/195'/0'，派生外部链地址 m/44'/195'/0'/0/i (0 <= i < count)，
+// 与 TronLink 等钱包使用相同助记词导入后的地址一致，资金由持有助记词的钱包归集
+func NewTronXPubAddressProvider(xpub string, count int) (*TronXPubAddressProvider, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("tron xpub address count must be positive")
+	}
+	b, err := tronBase58CheckDecode(xpub)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xpub: %w", err)
+	}
+	if len(b) != 78 || (b[45] != 0x02 && b[45] != 0x03) {
+		return nil, fmt.Errorf("invalid xpub: not an extended public key")
+	}
+	chainCode, key := b[13:45], b[45:78]
+
+	// 外部链 0
+	key, chainCode, err = tronChildPublicKey(key, chainCode, 0)
+	if err != nil {
+		return nil, err
+	}
+	p := &TronXPubAddressProvider{addresses: make([]string, 0, count)}
+	for i := range count {
+		child, _, err := tronChildPublicKey(key, chainCode, uint32(i))
+		if err != nil {
+			return nil, fmt.Errorf("derive tron address %d error: %w", i, err)
+		}
+		pub, err := secp256k1.ParsePubKey(child)
+		if err != nil {
+			return nil, err
+		}
+		p.addresses = append(p.addresses, tronPublicKeyAddress(pub))
+	}
+	return p, nil
+}
+
+func (p *TronXPubAddressProvider) Addresses() []string {
+	return p.addresses
+}
+
+// tronPublicKeyAddress 公钥对应的地址：0x41 + Keccak256(X || Y) 的后 20 字节
+func tronPublicKeyAddress(pub *secp256k1.PublicKey) string {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(pub.SerializeUncompressed()[1:])
+	return tronBase58Check(append([]byte{0x41}, h.Sum(nil)[12:]...))
+}
+
+// tronValidAddress 校验 Base58Check 地址格式
+func tronValidAddress(address string) bool {
+	b, err := tronBase58CheckDecode(address)
+	return err == nil && len(b) == 21 && b[0] == 0x41
+}
+
+// tronBase58CheckDecode Base58Check 解码并校验校验和，返回去掉校验和的数据
+func tronBase58CheckDecode(s string) ([]byte, error) {
+	x, base := new(big.Int), big.NewInt(58)
+	for _, c := range s {
+		i := strings.IndexRune(_BASE58_ALPHABET, c)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid base58 character: %q", c)
+		}
+		x.Mul(x, base).Add(x, big.NewInt(int64(i)))
+	}
+	b := x.Bytes()
+	for _, c := range s {
+		if c != rune(_BASE58_ALPHABET[0]) {
+			break
+		}
+		b = append([]byte{0}, b...)
+	}
+	if len(b) < 4 {
+		return nil, fmt.Errorf("invalid base58check length")
+	}
+	payload := b[:len(b)-4]
+	h1 := sha256.Sum256(payload)
+	h2 := sha256.Sum256(h1[:])
+	if string(h2[:4]) != string(b[len(b)-4:]) {
+		return nil, fmt.Errorf("invalid base58check checksum")
+	}
+	return payload, nil
+}
+
+// tronChildPublicKey BIP32 公钥派生(非硬化)，返回子公钥(压缩)及子链码
+func tronChildPublicKey(key, chainCode []byte, index uint32) ([]byte, []byte, error) {
+	if index >= 1<<31 {
+		return nil, nil, fmt.Errorf("hardened derivation requires private key")
+	}
+	parent, err := secp256k1.ParsePubKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	mac := hmac.New(sha512.New, chainCode)
+	mac.Write(key)
+	_ = binary.Write(mac, binary.BigEndian, index)
+	sum := mac.Sum(nil)
+
+	// 子公钥 = IL·G + 父公钥，IL 不小于曲线阶或结果为无穷远点时该索引无效
+	var il secp256k1.ModNScalar
+	if il.SetByteSlice(sum[:32]) {
+		return nil, nil, fmt.Errorf("invalid child key at index %d", index)
+	}
+	var point, parentPoint, child secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&il, &point)
+	parent.AsJacobian(&parentPoint)
+	secp256k1.AddNonConst(&point, &parentPoint, &child)
+	if child.Z.IsZero() {
+		return nil, nil, fmt.Errorf("invalid child key at index %d", index)
+	}
+	child.ToAffine()
+	return secp256k1.NewPublicKey(&child.X, &child.Y).SerializeCompressed(), sum[32:], nil
+}
@@ -0,0 +1,366 @@
+package paykit
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TronAmountAllocator 唯一金额分配策略
+//
+// 同一收款地址通过支付金额区分订单，Candidates 按优先顺序返回订单可用的支付金额(代币最小单位)，
+// TronClient 依次尝试占用，全部被占用时下单失败
+type TronAmountAllocator interface {
+	Candidates(amount int64, decimals int) []int64
+}
+
+// TronAmountDirection 金额偏移方向
+type TronAmountDirection string
+
+const (
+	TRON_AMOUNT_DIRECTION_UP     TronAmountDirection = ""       // 在原价基础上递增(默认)
+	TRON_AMOUNT_DIRECTION_DOWN   TronAmountDirection = "down"   // 在原价基础上递减，用户实付不高于原价
+	TRON_AMOUNT_DIRECTION_RANDOM TronAmountDirection = "random" // 在原价上下随机偏移
+)
+
+var _ TronAmountAllocator = TronAmountStrategy{}
+
+// TronAmountStrategy 默认的唯一金额分配策略，零值为：步长 0.01，最大偏移 1.00，向上递增
+type TronAmountStrategy struct {
+	Step                float64             `json:"step"`                // 步长(标准单位)，默认 0.01
+	MaxDeviation        float64             `json:"maxDeviation"`        // 最大偏移金额(标准单位)，未设置 MaxDeviationPercent 时默认 1.00
+	MaxDeviationPercent float64             `json:"maxDeviationPercent"` // 最大偏移比例(%)，如 1 表示不超过原价的 1%，与 MaxDeviation 同时设置时取较小值
+	Direction           TronAmountDirection `json:"direction"`           // 偏移方向
+}
+
+// Candidates 返回原价及偏移范围内的所有金额
+func (s TronAmountStrategy) Candidates(amount int64, decimals int) []int64 {
+	unit := math.Pow10(decimals)
+	step := int64(math.Round(s.Step * unit))
+	if step <= 0 {
+		step = max(tronPow10(decimals-2), 1)
+	}
+	maxDeviation := int64(-1)
+	if s.MaxDeviation > 0 {
+		maxDeviation = int64(math.Round(s.MaxDeviation * unit))
+	}
+	if s.MaxDeviationPercent > 0 {
+		d := int64(math.Floor(float64(amount) * s.MaxDeviationPercent / 100))
+		if maxDeviation < 0 || d < maxDeviation {
+			maxDeviation = d
+		}
+	}
+	if maxDeviation < 0 {
+		maxDeviation = tronPow10(decimals)
+	}
+
+	n := maxDeviation / step
+	candidates := make([]int64, 0, 2*n+1)
+	switch s.Direction {
+	case TRON_AMOUNT_DIRECTION_DOWN:
+		for i := int64(0); i <= n && amount-i*step > 0; i++ {
+			candidates = append(candidates, amount-i*step)
+		}
+	case TRON_AMOUNT_DIRECTION_RANDOM:
+		for i := -n; i <= n; i++ {
+			if amount+i*step > 0 {
+				candidates = append(candidates, amount+i*step)
+			}
+		}
+		rand.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+	default:
+		for i := int64(0); i <= n; i++ {
+			candidates = append(candidates, amount+i*step)
+		}
+	}
+	return candidates
+}
+
+// TronTolerance 付款金额容差，累计实付金额不低于应付金额减去容差时视为已付清，同时设置时取较小值，零值为不允许少付
+//
+// 唯一金额模式下默认只匹配金额完全一致或在容差范围内的付款。设置 MatchPercent 后，
+// 金额不一致的付款在匹配窗口内有且只有一个同一代币的待支付订单时计入该订单，据此上报少付、多付并累计多笔付款，
+// 存在多个订单时无法确定归属，作为无法匹配的付款上报。窗口过大时小额垃圾转账也会计入订单，请按需设置
+type TronTolerance struct {
+	Amount       float64 `json:"amount"`       // 容差金额(标准单位)
+	Percent      float64 `json:"percent"`      // 容差比例(%)，如 1 表示应付金额的 1%
+	MatchPercent float64 `json:"matchPercent"` // 唯一金额模式的匹配窗口(%)：付款金额与订单待付金额相差不超过待付金额的 MatchPercent% 时可计入该订单，默认为 0，仅在容差范围内匹配
+}
+
+// units 应付金额 amount(代币最小单位)对应的容差
+func (t TronTolerance) units(amount int64, decimals int) int64 {
+	tolerance := int64(-1)
+	if t.Amount > 0 {
+		tolerance = int64(math.Round(t.Amount * math.Pow10(decimals)))
+	}
+	if t.Percent > 0 {
+		d := int64(math.Floor(float64(amount) * t.Percent / 100))
+		if tolerance < 0 || d < tolerance {
+			tolerance = d
+		}
+	}
+	return max(tolerance, 0)
+}
+
+// window 唯一金额模式下待付金额 remaining 对应的匹配窗口，不小于容差
+func (t TronTolerance) window(amount, remaining int64, decimals int) int64 {
+	return max(int64(math.Floor(float64(remaining)*max(t.MatchPercent, 0)/100)), t.units(amount, decimals))
+}
+
+// tronMatch 转账匹配结果
+type tronMatch int
+
+const (
+	tronMatchNone    tronMatch = iota // 没有对应的订单
+	tronMatchPaid                     // 已付清，订单已释放
+	tronMatchPartial                  // 部分付款，订单仍待支付
+	tronMatchLate                     // 过期订单的逾期付款
+)
+
+// tronReservations 订单占用表，key=收款地址、代币及价格 value=订单，占用与释放在同一把锁内完成，避免并发下单时分配到相同的金额或地址
+type tronReservations struct {
+	mu      sync.Mutex
+	pending map[tronOrderKey]*tronOrder // 待支付订单
+	expired map[tronOrderKey]*tronOrder // 已过期订单，用于识别逾期付款
+}
+
+func newTronReservations() *tronReservations {
+	return &tronReservations{
+		pending: make(map[tronOrderKey]*tronOrder),
+		expired: make(map[tronOrderKey]*tronOrder),
+	}
+}
+
+// newTronReservation 持久化的订单占用
+func newTronReservation(key tronOrderKey, order *tronOrder) TronReservation {
+	o := order.snapshot()
+	return TronReservation{
+		OutTradeNo:     o.OutTradeNo,
+		Address:        key.Address,
+		TokenSymbol:    key.TokenSymbol,
+		Amount:         o.Amount,
+		UniqueAmount:   key.Amount > 0,
+		CreatedAt:      o.CreatedAt,
+		ExpiresAt:      o.ExpiresAt,
+		Paid:           o.Paid,
+		TransactionIDs: o.TransactionIDs,
+	}
+}
+
+// order 恢复订单占用的 key 及订单
+func (v TronReservation) order() (tronOrderKey, *tronOrder) {
+	key := tronOrderKey{Address: v.Address, TokenSymbol: v.TokenSymbol}
+	if v.UniqueAmount {
+		key.Amount = v.Amount
+	}
+	return key, &tronOrder{
+		OutTradeNo:     v.OutTradeNo,
+		Address:        v.Address,
+		TokenSymbol:    v.TokenSymbol,
+		Amount:         v.Amount,
+		CreatedAt:      v.CreatedAt,
+		ExpiresAt:      v.ExpiresAt,
+		Paid:           v.Paid,
+		TransactionIDs: slices.Clone(v.TransactionIDs),
+	}
+}
+
+// snapshot 待支付及已过期订单的副本，用于持久化
+func (r *tronReservations) snapshot() (pending, expired []TronReservation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, order := range r.pending {
+		pending = append(pending, newTronReservation(key, order))
+	}
+	for key, order := range r.expired {
+		expired = append(expired, newTronReservation(key, order))
+	}
+	return
+}
+
+// restore 恢复持久化的订单占用，已存在的 key 保持不变
+func (r *tronReservations) restore(pending, expired []TronReservation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range pending {
+		if key, order := v.order(); r.pending[key] == nil {
+			r.pending[key] = order
+		}
+	}
+	for _, v := range expired {
+		if key, order := v.order(); r.expired[key] == nil {
+			r.expired[key] = order
+		}
+	}
+}
+
+// reserve 按顺序占用第一个可用的 key，全部被占用时返回 false
+//
+// 优先占用没有过期订单的 key，减少逾期付款与新订单混淆。唯一金额模式下订单金额为占用的 key 的金额
+func (r *tronReservations) reserve(keys []tronOrderKey, order *tronOrder) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, skipExpired := range []bool{true, false} {
+		for _, key := range keys {
+			if _, ok := r.pending[key]; ok {
+				continue
+			}
+			if _, ok := r.expired[key]; ok && skipExpired {
+				continue
+			}
+			order.Address = key.Address
+			if key.Amount > 0 {
+				order.Amount = key.Amount
+			}
+			r.pending[key] = order
+			return true
+		}
+	}
+	return false
+}
+
+// match 将一笔转账计入对应的订单，返回计入后的订单快照及匹配结果
+//
+// 依次匹配相同 key 的待支付订单、相同 key 的已过期订单，唯一金额模式下最后在匹配窗口内查找唯一的待支付订单。
+// 同一订单的多笔转账累计计算。区块时间早于订单创建时间的转账不属于该订单
+func (r *tronReservations) match(key tronOrderKey, blockTime time.Time, amount int64, transactionID string, tolerance TronTolerance, decimals int) (tronOrder, tronMatch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if order, ok := r.pending[key]; ok && !blockTime.Before(order.CreatedAt) {
+		return r.pay(key, order, blockTime, amount, transactionID, tolerance.units(order.Amount, decimals))
+	}
+	if order, ok := r.expired[key]; ok && !blockTime.Before(order.CreatedAt) {
+		order.add(amount, transactionID)
+		return order.snapshot(), tronMatchLate
+	}
+	if order, k := r.nearest(key, blockTime, amount, tolerance, decimals); order != nil {
+		return r.pay(k, order, blockTime, amount, transactionID, tolerance.units(order.Amount, decimals))
+	}
+	return tronOrder{}, tronMatchNone
+}
+
+// pay 将转账计入待支付订单，付清时释放订单，区块时间晚于过期时间时转入过期订单
+func (r *tronReservations) pay(key tronOrderKey, order *tronOrder, blockTime time.Time, amount int64, transactionID string, tolerance int64) (tronOrder, tronMatch) {
+	order.add(amount, transactionID)
+	switch {
+	case blockTime.After(order.ExpiresAt):
+		delete(r.pending, key)
+		r.expired[key] = order
+		return order.snapshot(), tronMatchLate
+	case order.Paid >= order.Amount-tolerance:
+		delete(r.pending, key)
+		return order.snapshot(), tronMatchPaid
+	default:
+		return order.snapshot(), tronMatchPartial
+	}
+}
+
+// nearest 唯一金额模式下查找付款金额在待付金额匹配窗口内的唯一待支付订单，存在多个时无法确定归属，返回 nil
+func (r *tronReservations) nearest(key tronOrderKey, blockTime time.Time, amount int64, tolerance TronTolerance, decimals int) (*tronOrder, tronOrderKey) {
+	if key.Amount == 0 {
+		return nil, key
+	}
+	var (
+		found    *tronOrder
+		foundKey tronOrderKey
+	)
+	for k, order := range r.pending {
+		if k.Address != key.Address || k.TokenSymbol != key.TokenSymbol || blockTime.Before(order.CreatedAt) {
+			continue
+		}
+		remaining := order.Amount - order.Paid
+		if d := remaining - amount; max(d, -d) > tolerance.window(order.Amount, remaining, decimals) {
+			continue
+		}
+		if found != nil {
+			return nil, key
+		}
+		found, foundKey = order, k
+	}
+	return found, foundKey
+}
+
+// release 释放订单的占用
+func (r *tronReservations) release(outTradeNo string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, order := range r.pending {
+		if order.OutTradeNo == outTradeNo {
+			delete(r.pending, key)
+			return true
+		}
+	}
+	return false
+}
+
+// addresses 待支付及已过期订单的收款地址
+func (r *tronReservations) addresses() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	set := make(map[string]struct{})
+	for key := range r.pending {
+		set[key.Address] = struct{}{}
+	}
+	for key := range r.expired {
+		set[key.Address] = struct{}{}
+	}
+	addresses := make([]string, 0, len(set))
+	for address := range set {
+		addresses = append(addresses, address)
+	}
+	slices.Sort(addresses)
+	return addresses
+}
+
+// releaseExpired 释放超过 now 的订单并转入过期订单，清理超过逾期付款保留时间的过期订单，返回本次过期的订单
+func (r *tronReservations) releaseExpired(now time.Time) []tronOrder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var orders []tronOrder
+	for key, order := range r.pending {
+		if now.Before(order.ExpiresAt.Add(_TRON_EXPIRE_GRACE)) {
+			continue
+		}
+		delete(r.pending, key)
+		r.expired[key] = order
+		orders = append(orders, order.snapshot())
+	}
+	for key, order := range r.expired {
+		if now.After(order.ExpiresAt.Add(_TRON_LATE_PAYMENT_WINDOW)) {
+			delete(r.expired, key)
+		}
+	}
+	return orders
+}
+
+// tronPow10 10 的 n 次方，n < 0 时返回 0
+func tronPow10(n int) int64 {
+	if n < 0 {
+		return 0
+	}
+	v := int64(1)
+	for range n {
+		v *= 10
+	}
+	return v
+}
+
+// tronFormatAmount 将代币最小单位金额格式化为标准单位，至少保留 2 位小数，如 1230000(精度 6) 格式化为 1.23
+func tronFormatAmount(amount int64, decimals int) string {
+	s := strconv.FormatInt(amount, 10)
+	if len(s) <= decimals {
+		s = strings.Repeat("0", decimals-len(s)+1) + s
+	}
+	s = s[:len(s)-decimals] + "." + s[len(s)-decimals:]
+	for strings.HasSuffix(s, "0") && len(s)-strings.Index(s, ".") > 3 {
+		s = s[:len(s)-1]
+	}
+	return s
+}
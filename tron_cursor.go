@@ -0,0 +1,128 @@
+package paykit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TronScanCursor 交易扫描游标及订单占用，与已处理的交易 ID 一起保存，重启后恢复待支付订单并补扫停机期间的交易
+type TronScanCursor struct {
+	BlockTimestamp int64             `json:"blockTimestamp"`    // 最近一次完整扫描的最新区块时间(毫秒级时间戳)，下次从此处开始扫描
+	Seen           map[string]int64  `json:"seen"`              // 已处理的交易 ID 及其区块时间，用于重叠扫描时去重
+	Pending        []TronReservation `json:"pending,omitempty"` // 待支付订单
+	Expired        []TronReservation `json:"expired,omitempty"` // 已过期订单，用于识别逾期付款
+}
+
+// TronReservation 订单占用，唯一金额模式下订单占用收款地址、代币及金额，独立地址模式下占用收款地址及代币
+type TronReservation struct {
+	OutTradeNo     string      `json:"outTradeNo"`
+	Address        string      `json:"address"` // 收款地址
+	TokenSymbol    TokenSymbol `json:"tokenSymbol"`
+	Amount         int64       `json:"amount"`         // 应付金额，代币最小单位
+	UniqueAmount   bool        `json:"uniqueAmount"`   // 是否为唯一金额模式，即订单是否占用金额
+	CreatedAt      time.Time   `json:"createdAt"`      // 下单时间，区块时间早于该时间的转账不属于该订单
+	ExpiresAt      time.Time   `json:"expiresAt"`      // 过期时间
+	Paid           int64       `json:"paid"`           // 累计实付金额，代币最小单位
+	TransactionIDs []string    `json:"transactionIDs"` // 已收到的付款交易哈希
+}
+
+func (c *TronScanCursor) seen(transactionID string) bool {
+	_, ok := c.Seen[transactionID]
+	return ok
+}
+
+func (c *TronScanCursor) mark(transactionID string, blockTimestamp int64) {
+	if c.Seen == nil {
+		c.Seen = make(map[string]int64)
+	}
+	c.Seen[transactionID] = blockTimestamp
+}
+
+// prune 清理区块时间早于 before 的交易 ID，这些交易不会再被扫描到
+func (c *TronScanCursor) prune(before int64) {
+	for id, ts := range c.Seen {
+		if ts < before {
+			delete(c.Seen, id)
+		}
+	}
+}
+
+// TronCursorStore 扫描游标存储，重启后恢复待支付订单并从游标处继续扫描，补齐停机期间的交易
+//
+// 游标包含订单占用信息，需完整保存(如 JSON 序列化)
+type TronCursorStore interface {
+	Load(ctx context.Context) (*TronScanCursor, error) // 游标不存在时返回 nil, nil
+	Save(ctx context.Context, cursor *TronScanCursor) error
+}
+
+// tronMemoryCursorStore 内存游标存储，未配置 TronConfig.CursorStore 时使用，重启后丢失
+type tronMemoryCursorStore struct {
+	mu     sync.Mutex
+	cursor []byte
+}
+
+func (s *tronMemoryCursorStore) Load(ctx context.Context) (*TronScanCursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cursor == nil {
+		return nil, nil
+	}
+	var cursor *TronScanCursor
+	err := json.Unmarshal(s.cursor, &cursor)
+	return cursor, err
+}
+
+func (s *tronMemoryCursorStore) Save(ctx context.Context, cursor *TronScanCursor) error {
+	b, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cursor = b
+	return nil
+}
+
+// TronFileCursorStore 以 JSON 文件保存扫描游标
+type TronFileCursorStore struct {
+	Path string
+}
+
+// NewTronFileCursorStore 创建文件游标存储，path 为游标文件路径，目录不存在时自动创建
+func NewTronFileCursorStore(path string) *TronFileCursorStore {
+	return &TronFileCursorStore{Path: path}
+}
+
+func (s *TronFileCursorStore) Load(ctx context.Context) (*TronScanCursor, error) {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cursor *TronScanCursor
+	err = json.Unmarshal(b, &cursor)
+	return cursor, err
+}
+
+// Save 先写入临时文件再重命名，避免进程中断时游标文件损坏
+func (s *TronFileCursorStore) Save(ctx context.Context, cursor *TronScanCursor) error {
+	b, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.Path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
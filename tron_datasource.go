@@ -0,0 +1,717 @@
+package paykit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/os/glog"
+)
+
+// TronNetwork 波场网络
+type TronNetwork string
+
+const (
+	TRON_NETWORK_MAINNET TronNetwork = "mainnet" // 主网
+	TRON_NETWORK_NILE    TronNetwork = "nile"    // Nile 测试网
+	TRON_NETWORK_SHASTA  TronNetwork = "shasta"  // Shasta 测试网
+)
+
+var (
+	_TRONGRID_ENDPOINTS = map[TronNetwork]string{
+		TRON_NETWORK_MAINNET: "https://api.trongrid.io",
+		TRON_NETWORK_NILE:    "https://nile.trongrid.io",
+		TRON_NETWORK_SHASTA:  "https://api.shasta.trongrid.io",
+	}
+	_TRONSCAN_ENDPOINTS = map[TronNetwork]string{
+		TRON_NETWORK_MAINNET: "https://apilist.tronscanapi.com",
+		TRON_NETWORK_NILE:    "https://nileapi.tronscan.org",
+		TRON_NETWORK_SHASTA:  "https://shastapi.tronscan.org",
+	}
+)
+
+const (
+	_TRONGRID_TRC20_PATH      = "/v1/accounts/{address}/transactions/trc20"
+	_TRONGRID_TRX_PATH        = "/v1/accounts/{address}/transactions"
+	_TRONSCAN_TRC20_PATH      = "/api/token_trc20/transfers"
+	_TRONSCAN_TRX_PATH        = "/api/transfer"
+	_TRON_NODE_NOW_BLOCK_PATH = "/walletsolidity/getnowblock"
+	_TRON_NODE_BLOCK_PATH     = "/walletsolidity/getblockbynum"
+	_TRON_NODE_TX_INFO_PATH   = "/walletsolidity/gettransactioninfobyblocknum"
+	_TRON_NODE_TRIGGER_PATH   = "/walletsolidity/triggerconstantcontract"
+	_TRON_API_KEY_HEADER      = "TRON-PRO-API-KEY"
+	_TRON_TRANSFER_TOPIC      = "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef" // Transfer(address,address,uint256)
+	_TRONGRID_PAGE_SIZE       = 200                                                                // TronGrid 单页最大条数
+	_TRONSCAN_PAGE_SIZE       = 50                                                                 // TronScan 单页最大条数
+	_TRON_NODE_PAGE_BLOCKS    = 20                                                                 // java-tron 每页扫描的区块数
+	_TRON_BLOCK_INTERVAL      = 3000                                                               // 出块间隔(毫秒)
+)
+
+// TronTransfer 归一化的 TRC20 及 TRX 转账记录
+type TronTransfer struct {
+	TransactionID  string `json:"transactionID"`  // 交易哈希
+	Contract       string `json:"contract"`       // 代币合约地址(Base58)，TRX 转账为空
+	Decimals       int    `json:"decimals"`       // 代币精度
+	From           string `json:"from"`           // 付款地址(Base58)
+	To             string `json:"to"`             // 收款地址(Base58)
+	Value          string `json:"value"`          // 转账金额，代币最小单位
+	BlockTimestamp int64  `json:"blockTimestamp"` // 区块时间(毫秒级时间戳)
+}
+
+// TronDataSource 链上数据源
+type TronDataSource interface {
+	// TRC20Transfers 分页查询 address 在 [minTimestamp, maxTimestamp] 区间内已确认的 TRC20 转入记录，
+	// cursor 为分页游标，首页为空，返回的 next 为空时表示没有更多数据
+	TRC20Transfers(ctx context.Context, address string, minTimestamp, maxTimestamp int64, cursor string) (transfers []*TronTransfer, next string, err error)
+	// TRXTransfers 分页查询 address 在 [minTimestamp, maxTimestamp] 区间内已确认且成功的 TRX 转入记录，金额单位为 sun，分页规则同上
+	TRXTransfers(ctx context.Context, address string, minTimestamp, maxTimestamp int64, cursor string) (transfers []*TronTransfer, next string, err error)
+}
+
+// TronDataSourceConfig 数据源配置
+type TronDataSourceConfig struct {
+	Network  TronNetwork // 网络
+	Endpoint string      // 接口地址，为空时使用网络对应的默认地址。java-tron 必填，为 solidity HTTP 接口地址，如 http://127.0.0.1:8091
+	APIKey   string      // API 密钥，以 TRON-PRO-API-KEY 请求头发送
+}
+
+func (c TronDataSourceConfig) endpoint(defaults map[TronNetwork]string) (string, error) {
+	if c.Endpoint != "" {
+		return strings.TrimSuffix(c.Endpoint, "/"), nil
+	}
+	if endpoint, ok := defaults[c.Network]; ok {
+		return endpoint, nil
+	}
+	return "", fmt.Errorf("tron network %q endpoint not configured", c.Network)
+}
+
+func (c TronDataSourceConfig) httpClient() *gclient.Client {
+	client := gclient.New()
+	if c.APIKey != "" {
+		client.SetHeader(_TRON_API_KEY_HEADER, c.APIKey)
+	}
+	return client
+}
+
+// TronGridDataSource TronGrid 数据源
+//
+//	docs:
+//	https://developers.tron.network/reference/get-trc20-transaction-info-by-account-address
+type TronGridDataSource struct {
+	endpoint   string
+	httpClient *gclient.Client
+}
+
+var _ TronDataSource = (*TronGridDataSource)(nil)
+
+func NewTronGridDataSource(config TronDataSourceConfig) (*TronGridDataSource, error) {
+	endpoint, err := config.endpoint(_TRONGRID_ENDPOINTS)
+	if err != nil {
+		return nil, err
+	}
+	return &TronGridDataSource{endpoint: endpoint, httpClient: config.httpClient()}, nil
+}
+
+type trongridRes struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+	Data    []struct {
+		TransactionID string `json:"transaction_id"`
+		TokenInfo     struct {
+			Symbol   string `json:"symbol"`
+			Address  string `json:"address"`
+			Decimals int    `json:"decimals"`
+			Name     string `json:"name"`
+		} `json:"token_info"`
+		BlockTimestamp int64  `json:"block_timestamp"`
+		From           string `json:"from"`
+		To             string `json:"to"`
+		Type           string `json:"type"`
+		Value          string `json:"value"`
+	} `json:"data"`
+	Meta struct {
+		At          int64  `json:"at"`
+		Fingerprint string `json:"fingerprint"` // 下一页游标，为空时表示没有更多数据
+		PageSize    int    `json:"page_size"`
+	} `json:"meta"`
+}
+
+type trongridTRXRes struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+	Data    []struct {
+		TxID           string `json:"txID"`
+		BlockTimestamp int64  `json:"block_timestamp"`
+		Ret            []struct {
+			ContractRet string `json:"contractRet"`
+		} `json:"ret"`
+		RawData struct {
+			Contract []tronTransferContract `json:"contract"`
+		} `json:"raw_data"`
+	} `json:"data"`
+	Meta struct {
+		Fingerprint string `json:"fingerprint"`
+	} `json:"meta"`
+}
+
+// tronTransferContract 交易中的合约调用，TRX 转账类型为 TransferContract，地址为十六进制
+type tronTransferContract struct {
+	Type      string `json:"type"`
+	Parameter struct {
+		Value struct {
+			Amount       int64  `json:"amount"`
+			OwnerAddress string `json:"owner_address"`
+			ToAddress    string `json:"to_address"`
+		} `json:"value"`
+	} `json:"parameter"`
+}
+
+// trx 解析 TRX 转账，非 TransferContract 或地址无效时返回 false
+func (c tronTransferContract) trx(transactionID string, blockTimestamp int64) (*TronTransfer, bool) {
+	if c.Type != "TransferContract" {
+		return nil, false
+	}
+	to, err := tronHexToBase58(c.Parameter.Value.ToAddress)
+	if err != nil {
+		return nil, false
+	}
+	from, _ := tronHexToBase58(c.Parameter.Value.OwnerAddress)
+	return &TronTransfer{
+		TransactionID:  transactionID,
+		Decimals:       _TRX_DECIMALS,
+		From:           from,
+		To:             to,
+		Value:          strconv.FormatInt(c.Parameter.Value.Amount, 10),
+		BlockTimestamp: blockTimestamp,
+	}, true
+}
+
+func (s *TronGridDataSource) params(minTimestamp, maxTimestamp int64, cursor string) map[string]any {
+	params := map[string]any{
+		"only_confirmed": true,
+		"only_to":        true,
+		"order_by":       "block_timestamp,asc",
+		"limit":          _TRONGRID_PAGE_SIZE,
+		"min_timestamp":  minTimestamp,
+		"max_timestamp":  maxTimestamp,
+	}
+	if cursor != "" {
+		params["fingerprint"] = cursor
+	}
+	return params
+}
+
+func (s *TronGridDataSource) TRC20Transfers(ctx context.Context, address string, minTimestamp, maxTimestamp int64, cursor string) ([]*TronTransfer, string, error) {
+	var res *trongridRes
+	url := s.endpoint + strings.ReplaceAll(_TRONGRID_TRC20_PATH, "{address}", address)
+	if err := s.httpClient.GetVar(ctx, url, s.params(minTimestamp, maxTimestamp, cursor)).Scan(&res); err != nil {
+		return nil, "", err
+	}
+	if res == nil || !res.Success {
+		return nil, "", fmt.Errorf("trongrid response error: %+v", res)
+	}
+
+	transfers := make([]*TronTransfer, 0, len(res.Data))
+	for _, v := range res.Data {
+		if v.Type != "Transfer" {
+			continue
+		}
+		transfers = append(transfers, &TronTransfer{
+			TransactionID:  v.TransactionID,
+			Contract:       v.TokenInfo.Address,
+			Decimals:       v.TokenInfo.Decimals,
+			From:           v.From,
+			To:             v.To,
+			Value:          v.Value,
+			BlockTimestamp: v.BlockTimestamp,
+		})
+	}
+	if len(res.Data) == 0 {
+		return transfers, "", nil
+	}
+	return transfers, res.Meta.Fingerprint, nil
+}
+
+// TRXTransfers 账户交易列表中包含内部交易及其他合约调用，仅保留 TransferContract
+func (s *TronGridDataSource) TRXTransfers(ctx context.Context, address string, minTimestamp, maxTimestamp int64, cursor string) ([]*TronTransfer, string, error) {
+	var res *trongridTRXRes
+	url := s.endpoint + strings.ReplaceAll(_TRONGRID_TRX_PATH, "{address}", address)
+	if err := s.httpClient.GetVar(ctx, url, s.params(minTimestamp, maxTimestamp, cursor)).Scan(&res); err != nil {
+		return nil, "", err
+	}
+	if res == nil || !res.Success {
+		return nil, "", fmt.Errorf("trongrid response error: %+v", res)
+	}
+
+	transfers := make([]*TronTransfer, 0, len(res.Data))
+	for _, v := range res.Data {
+		if len(v.Ret) == 0 || v.Ret[0].ContractRet != "SUCCESS" || len(v.RawData.Contract) == 0 {
+			continue
+		}
+		if transfer, ok := v.RawData.Contract[0].trx(v.TxID, v.BlockTimestamp); ok && transfer.To == address {
+			transfers = append(transfers, transfer)
+		}
+	}
+	if len(res.Data) == 0 {
+		return transfers, "", nil
+	}
+	return transfers, res.Meta.Fingerprint, nil
+}
+
+// TronScanDataSource TronScan 数据源
+//
+//	docs:
+//	https://docs.tronscan.org/api-endpoints/transactions-and-transfers
+type TronScanDataSource struct {
+	endpoint   string
+	httpClient *gclient.Client
+}
+
+var _ TronDataSource = (*TronScanDataSource)(nil)
+
+func NewTronScanDataSource(config TronDataSourceConfig) (*TronScanDataSource, error) {
+	endpoint, err := config.endpoint(_TRONSCAN_ENDPOINTS)
+	if err != nil {
+		return nil, err
+	}
+	return &TronScanDataSource{endpoint: endpoint, httpClient: config.httpClient()}, nil
+}
+
+type tronscanRes struct {
+	Total          int `json:"total"`
+	TokenTransfers []struct {
+		TransactionID   string `json:"transaction_id"`
+		BlockTs         int64  `json:"block_ts"`
+		FromAddress     string `json:"from_address"`
+		ToAddress       string `json:"to_address"`
+		ContractAddress string `json:"contract_address"`
+		Quant           string `json:"quant"`
+		Confirmed       bool   `json:"confirmed"`
+		ContractRet     string `json:"contractRet"`
+		TokenInfo       struct {
+			TokenAbbr    string `json:"tokenAbbr"`
+			TokenDecimal int    `json:"tokenDecimal"`
+		} `json:"tokenInfo"`
+	} `json:"token_transfers"`
+}
+
+type tronscanTRXRes struct {
+	Total int `json:"total"`
+	Data  []struct {
+		TransactionHash     string `json:"transactionHash"`
+		Timestamp           int64  `json:"timestamp"`
+		TransferFromAddress string `json:"transferFromAddress"`
+		TransferToAddress   string `json:"transferToAddress"`
+		Amount              string `json:"amount"`
+		TokenName           string `json:"tokenName"` // TRX 为 _
+		Confirmed           bool   `json:"confirmed"`
+		ContractRet         string `json:"contractRet"`
+	} `json:"data"`
+}
+
+// TRC20Transfers cursor 为分页偏移量
+func (s *TronScanDataSource) TRC20Transfers(ctx context.Context, address string, minTimestamp, maxTimestamp int64, cursor string) ([]*TronTransfer, string, error) {
+	start, _ := strconv.Atoi(cursor)
+	params := map[string]any{
+		"toAddress":       address,
+		"start_timestamp": minTimestamp,
+		"end_timestamp":   maxTimestamp,
+		"confirm":         true,
+		"sort":            "timestamp",
+		"start":           start,
+		"limit":           _TRONSCAN_PAGE_SIZE,
+	}
+	var res *tronscanRes
+	if err := s.httpClient.GetVar(ctx, s.endpoint+_TRONSCAN_TRC20_PATH, params).Scan(&res); err != nil {
+		return nil, "", err
+	}
+	if res == nil {
+		return nil, "", fmt.Errorf("tronscan response error: empty response")
+	}
+
+	transfers := make([]*TronTransfer, 0, len(res.TokenTransfers))
+	for _, v := range res.TokenTransfers {
+		if !v.Confirmed || (v.ContractRet != "" && v.ContractRet != "SUCCESS") {
+			continue
+		}
+		transfers = append(transfers, &TronTransfer{
+			TransactionID:  v.TransactionID,
+			Contract:       v.ContractAddress,
+			Decimals:       v.TokenInfo.TokenDecimal,
+			From:           v.FromAddress,
+			To:             v.ToAddress,
+			Value:          v.Quant,
+			BlockTimestamp: v.BlockTs,
+		})
+	}
+	next := start + len(res.TokenTransfers)
+	if len(res.TokenTransfers) == 0 || next >= res.Total {
+		return transfers, "", nil
+	}
+	return transfers, strconv.Itoa(next), nil
+}
+
+// TRXTransfers cursor 为分页偏移量，tokens 为 _ 时仅查询 TRX 转账
+func (s *TronScanDataSource) TRXTransfers(ctx context.Context, address string, minTimestamp, maxTimestamp int64, cursor string) ([]*TronTransfer, string, error) {
+	start, _ := strconv.Atoi(cursor)
+	params := map[string]any{
+		"toAddress":       address,
+		"tokens":          "_",
+		"start_timestamp": minTimestamp,
+		"end_timestamp":   maxTimestamp,
+		"sort":            "timestamp",
+		"count":           true,
+		"start":           start,
+		"limit":           _TRONSCAN_PAGE_SIZE,
+	}
+	var res *tronscanTRXRes
+	if err := s.httpClient.GetVar(ctx, s.endpoint+_TRONSCAN_TRX_PATH, params).Scan(&res); err != nil {
+		return nil, "", err
+	}
+	if res == nil {
+		return nil, "", fmt.Errorf("tronscan response error: empty response")
+	}
+
+	transfers := make([]*TronTransfer, 0, len(res.Data))
+	for _, v := range res.Data {
+		if !v.Confirmed || v.TokenName != "_" || v.TransferToAddress != address || (v.ContractRet != "" && v.ContractRet != "SUCCESS") {
+			continue
+		}
+		transfers = append(transfers, &TronTransfer{
+			TransactionID:  v.TransactionHash,
+			Decimals:       _TRX_DECIMALS,
+			From:           v.TransferFromAddress,
+			To:             v.TransferToAddress,
+			Value:          v.Amount,
+			BlockTimestamp: v.Timestamp,
+		})
+	}
+	next := start + len(res.Data)
+	if len(res.Data) == 0 || next >= res.Total {
+		return transfers, "", nil
+	}
+	return transfers, strconv.Itoa(next), nil
+}
+
+// TronNodeDataSource 自建 java-tron 节点数据源，逐个区块解析 Transfer 事件日志及 TRX 转账，仅扫描已固化的区块
+//
+//	docs:
+//	https://developers.tron.network/reference/full-node-api-overview
+type TronNodeDataSource struct {
+	endpoint   string
+	httpClient *gclient.Client
+	logger     *glog.Logger
+	decimals   sync.Map // 合约地址 -> 精度
+
+	mu        sync.RWMutex
+	contracts map[string]struct{} // 可接受的代币合约，为空时解析全部合约
+}
+
+// tronContractWatcher 需要预先知道可接受代币合约的数据源，由 NewTron 注入
+type tronContractWatcher interface {
+	watchContracts(contracts []string)
+}
+
+// tronBlockScanner 逐个区块扫描的数据源，每页区块只拉取一次并同时匹配全部收款地址的 TRC20 及 TRX 转账
+type tronBlockScanner interface {
+	blockTransfers(ctx context.Context, addresses []string, trc20, trx bool, minTimestamp, maxTimestamp int64, cursor string) ([]*TronTransfer, string, error)
+}
+
+var (
+	_ TronDataSource      = (*TronNodeDataSource)(nil)
+	_ tronContractWatcher = (*TronNodeDataSource)(nil)
+	_ tronBlockScanner    = (*TronNodeDataSource)(nil)
+)
+
+func NewTronNodeDataSource(config TronDataSourceConfig) (*TronNodeDataSource, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("tron node endpoint not configured")
+	}
+	endpoint, err := config.endpoint(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &TronNodeDataSource{endpoint: endpoint, httpClient: config.httpClient(), logger: newTronLogger()}, nil
+}
+
+// watchContracts 仅解析可接受代币合约的 Transfer 事件，避免查询任意合约的精度
+func (s *TronNodeDataSource) watchContracts(contracts []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.contracts = make(map[string]struct{}, len(contracts))
+	for _, contract := range contracts {
+		s.contracts[contract] = struct{}{}
+	}
+}
+
+func (s *TronNodeDataSource) watching(contract string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.contracts == nil {
+		return true
+	}
+	_, ok := s.contracts[contract]
+	return ok
+}
+
+type tronNodeBlock struct {
+	BlockHeader struct {
+		RawData struct {
+			Number    int64 `json:"number"`
+			Timestamp int64 `json:"timestamp"`
+		} `json:"raw_data"`
+	} `json:"block_header"`
+}
+
+type tronNodeTxInfo struct {
+	ID             string `json:"id"`
+	BlockNumber    int64  `json:"blockNumber"`
+	BlockTimeStamp int64  `json:"blockTimeStamp"`
+	Result         string `json:"result"` // 失败时为 FAILED
+	Log            []struct {
+		Address string   `json:"address"`
+		Topics  []string `json:"topics"`
+		Data    string   `json:"data"`
+	} `json:"log"`
+}
+
+type tronNodeBlockTxs struct {
+	tronNodeBlock
+	Transactions []struct {
+		TxID string `json:"txID"`
+		Ret  []struct {
+			ContractRet string `json:"contractRet"`
+		} `json:"ret"`
+		RawData struct {
+			Contract []tronTransferContract `json:"contract"`
+		} `json:"raw_data"`
+	} `json:"transactions"`
+}
+
+// blocks 本页扫描的区块范围 [start, end] 及下一页游标，cursor 为下一个待扫描的区块高度，首页根据 minTimestamp 估算起始区块
+func (s *TronNodeDataSource) blocks(ctx context.Context, minTimestamp int64, cursor string) (start, end int64, next string, err error) {
+	var latest *tronNodeBlock
+	if err = s.httpClient.ContentJson().PostVar(ctx, s.endpoint+_TRON_NODE_NOW_BLOCK_PATH).Scan(&latest); err != nil {
+		return
+	}
+	if latest == nil {
+		return 0, 0, "", fmt.Errorf("tron node getnowblock error: empty response")
+	}
+	head := latest.BlockHeader.RawData
+	start, err = strconv.ParseInt(cursor, 10, 64)
+	if err != nil {
+		start, err = max(head.Number-(head.Timestamp-minTimestamp)/_TRON_BLOCK_INTERVAL-1, 0), nil
+	}
+	end = min(start+_TRON_NODE_PAGE_BLOCKS-1, head.Number)
+	if end < head.Number {
+		next = strconv.FormatInt(end+1, 10)
+	}
+	return
+}
+
+// TRC20Transfers cursor 为下一个待扫描的区块高度
+func (s *TronNodeDataSource) TRC20Transfers(ctx context.Context, address string, minTimestamp, maxTimestamp int64, cursor string) ([]*TronTransfer, string, error) {
+	return s.blockTransfers(ctx, []string{address}, true, false, minTimestamp, maxTimestamp, cursor)
+}
+
+// TRXTransfers cursor 为下一个待扫描的区块高度
+func (s *TronNodeDataSource) TRXTransfers(ctx context.Context, address string, minTimestamp, maxTimestamp int64, cursor string) ([]*TronTransfer, string, error) {
+	return s.blockTransfers(ctx, []string{address}, false, true, minTimestamp, maxTimestamp, cursor)
+}
+
+// blockTransfers 本页每个区块只拉取一次，同时匹配全部收款地址的转入交易，trc20、trx 指定需要解析的转账类型
+func (s *TronNodeDataSource) blockTransfers(ctx context.Context, addresses []string, trc20, trx bool, minTimestamp, maxTimestamp int64, cursor string) ([]*TronTransfer, string, error) {
+	start, end, next, err := s.blocks(ctx, minTimestamp, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	watched := make(map[string]struct{}, len(addresses))
+	for _, address := range addresses {
+		watched[address] = struct{}{}
+	}
+	var transfers []*TronTransfer
+	for num := start; num <= end; num++ {
+		var done bool
+		if trc20 {
+			v, exceeded, err := s.blockTRC20Transfers(ctx, num, watched, minTimestamp, maxTimestamp)
+			if err != nil {
+				return nil, "", err
+			}
+			transfers, done = append(transfers, v...), exceeded
+		}
+		if trx && !done {
+			v, exceeded, err := s.blockTRXTransfers(ctx, num, watched, minTimestamp, maxTimestamp)
+			if err != nil {
+				return nil, "", err
+			}
+			transfers, done = append(transfers, v...), exceeded
+		}
+		// 区块时间超过 maxTimestamp，后续区块无需扫描
+		if done {
+			return transfers, "", nil
+		}
+	}
+	return transfers, next, nil
+}
+
+// blockTRC20Transfers 解析区块内转入 watched 地址的 Transfer 事件日志，exceeded 表示区块时间超过 maxTimestamp
+func (s *TronNodeDataSource) blockTRC20Transfers(ctx context.Context, num int64, watched map[string]struct{}, minTimestamp, maxTimestamp int64) (transfers []*TronTransfer, exceeded bool, err error) {
+	var infos []*tronNodeTxInfo
+	err = s.httpClient.ContentJson().PostVar(ctx, s.endpoint+_TRON_NODE_TX_INFO_PATH, map[string]any{"num": num}).Scan(&infos)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, info := range infos {
+		if info.BlockTimeStamp > maxTimestamp {
+			return transfers, true, nil
+		}
+		if info.BlockTimeStamp < minTimestamp || info.Result == "FAILED" {
+			continue
+		}
+		for _, l := range info.Log {
+			if len(l.Topics) != 3 || l.Topics[0] != _TRON_TRANSFER_TOPIC || len(l.Topics[1]) != 64 || len(l.Topics[2]) != 64 {
+				continue
+			}
+			to, err := tronHexToBase58(l.Topics[2][24:])
+			if err != nil {
+				continue
+			}
+			if _, ok := watched[to]; !ok {
+				continue
+			}
+			from, _ := tronHexToBase58(l.Topics[1][24:])
+			contract, err := tronHexToBase58(l.Address)
+			if err != nil || !s.watching(contract) {
+				continue
+			}
+			value, ok := new(big.Int).SetString(l.Data, 16)
+			if !ok {
+				continue
+			}
+			// 精度查询失败的合约(如仿冒代币的 decimals() 执行失败)跳过，不影响其他交易的扫描
+			decimals, err := s.contractDecimals(ctx, to, contract)
+			if err != nil {
+				s.logger.Errorf(ctx, "tron node contract %s decimals error, transaction: %s, %s", contract, info.ID, err.Error())
+				continue
+			}
+			transfers = append(transfers, &TronTransfer{
+				TransactionID:  info.ID,
+				Contract:       contract,
+				Decimals:       decimals,
+				From:           from,
+				To:             to,
+				Value:          value.String(),
+				BlockTimestamp: info.BlockTimeStamp,
+			})
+		}
+	}
+	return transfers, false, nil
+}
+
+// blockTRXTransfers 解析区块内转入 watched 地址且成功的 TRX 转账，exceeded 表示区块时间超过 maxTimestamp
+func (s *TronNodeDataSource) blockTRXTransfers(ctx context.Context, num int64, watched map[string]struct{}, minTimestamp, maxTimestamp int64) (transfers []*TronTransfer, exceeded bool, err error) {
+	var block *tronNodeBlockTxs
+	err = s.httpClient.ContentJson().PostVar(ctx, s.endpoint+_TRON_NODE_BLOCK_PATH, map[string]any{"num": num}).Scan(&block)
+	if err != nil {
+		return nil, false, err
+	}
+	if block == nil {
+		return nil, false, nil
+	}
+	ts := block.BlockHeader.RawData.Timestamp
+	if ts > maxTimestamp {
+		return nil, true, nil
+	}
+	if ts < minTimestamp {
+		return nil, false, nil
+	}
+	for _, tx := range block.Transactions {
+		if len(tx.Ret) == 0 || tx.Ret[0].ContractRet != "SUCCESS" || len(tx.RawData.Contract) == 0 {
+			continue
+		}
+		transfer, ok := tx.RawData.Contract[0].trx(tx.TxID, ts)
+		if !ok {
+			continue
+		}
+		if _, ok = watched[transfer.To]; ok {
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers, false, nil
+}
+
+// contractDecimals 调用合约 decimals() 查询精度，结果缓存
+func (s *TronNodeDataSource) contractDecimals(ctx context.Context, owner, contract string) (int, error) {
+	if v, ok := s.decimals.Load(contract); ok {
+		return v.(int), nil
+	}
+	var res *struct {
+		ConstantResult []string `json:"constant_result"`
+	}
+	err := s.httpClient.ContentJson().PostVar(ctx, s.endpoint+_TRON_NODE_TRIGGER_PATH, map[string]any{
+		"owner_address":     owner,
+		"contract_address":  contract,
+		"function_selector": "decimals()",
+		"visible":           true,
+	}).Scan(&res)
+	if err != nil {
+		return 0, err
+	}
+	if res == nil || len(res.ConstantResult) == 0 {
+		return 0, fmt.Errorf("tron node contract %s decimals() empty result", contract)
+	}
+	decimals, ok := new(big.Int).SetString(res.ConstantResult[0], 16)
+	if !ok {
+		return 0, fmt.Errorf("tron node contract %s invalid decimals: %s", contract, res.ConstantResult[0])
+	}
+	s.decimals.Store(contract, int(decimals.Int64()))
+	return int(decimals.Int64()), nil
+}
+
+const _BASE58_ALPHABET = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// tronHexToBase58 将十六进制地址转换为 Base58Check 地址，20 字节地址自动补充 0x41 前缀
+func tronHexToBase58(s string) (string, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 20 {
+		b = append([]byte{0x41}, b...)
+	}
+	if len(b) != 21 || b[0] != 0x41 {
+		return "", fmt.Errorf("invalid tron address: %s", s)
+	}
+	return tronBase58Check(b), nil
+}
+
+// tronBase58Check Base58Check 编码，校验和为两次 SHA256 的前 4 字节
+func tronBase58Check(payload []byte) string {
+	h1 := sha256.Sum256(payload)
+	h2 := sha256.Sum256(h1[:])
+	b := append(append([]byte{}, payload...), h2[:4]...)
+
+	x := new(big.Int).SetBytes(b)
+	base, mod := big.NewInt(58), new(big.Int)
+	var out []byte
+	for x.Sign() > 0 {
+		x.DivMod(x, base, mod)
+		out = append(out, _BASE58_ALPHABET[mod.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, _BASE58_ALPHABET[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
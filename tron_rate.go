@@ -0,0 +1,71 @@
+package paykit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/gogf/gf/v2/os/gcache"
+)
+
+const (
+	_BINANCE_PRICE_API   = "https://api.binance.com/api/v3/ticker/price"
+	_TRON_RATE_CACHE_TTL = time.Minute // 代币价格缓存时间
+)
+
+// TronRateSource 非稳定币的美元价格来源，TradePrecreate 按下单时的价格计算支付金额
+type TronRateSource interface {
+	USDPrice(ctx context.Context, symbol TokenSymbol) (float64, error) // 1 个代币对应的美元价格
+}
+
+// TronRateFunc 函数形式的 TronRateSource，如固定价格或自有行情服务
+type TronRateFunc func(ctx context.Context, symbol TokenSymbol) (float64, error)
+
+func (f TronRateFunc) USDPrice(ctx context.Context, symbol TokenSymbol) (float64, error) {
+	return f(ctx, symbol)
+}
+
+// TronBinanceRateSource Binance 现货价格，以 USDT 交易对近似美元价格，结果缓存 1 分钟
+//
+//	docs:
+//	https://developers.binance.com/docs/binance-spot-api-docs/rest-api/market-data-endpoints#symbol-price-ticker
+type TronBinanceRateSource struct {
+	httpClient *gclient.Client
+	cache      *gcache.Cache
+}
+
+var _ TronRateSource = (*TronBinanceRateSource)(nil)
+
+func NewTronBinanceRateSource() *TronBinanceRateSource {
+	return &TronBinanceRateSource{
+		httpClient: gclient.New(),
+		cache:      gcache.New(),
+	}
+}
+
+func (s *TronBinanceRateSource) USDPrice(ctx context.Context, symbol TokenSymbol) (float64, error) {
+	v, err := s.cache.GetOrSetFunc(ctx, symbol, func(ctx context.Context) (any, error) {
+		var res *struct {
+			Symbol string `json:"symbol"`
+			Price  string `json:"price"`
+		}
+		err := s.httpClient.GetVar(ctx, _BINANCE_PRICE_API, map[string]any{"symbol": string(symbol) + "USDT"}).Scan(&res)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			return nil, fmt.Errorf("binance %sUSDT price empty response", symbol)
+		}
+		return strconv.ParseFloat(res.Price, 64)
+	}, _TRON_RATE_CACHE_TTL)
+	if err != nil {
+		return 0, err
+	}
+	price := v.Float64()
+	if price <= 0 {
+		return 0, fmt.Errorf("invalid %s price: %v", symbol, price)
+	}
+	return price, nil
+}